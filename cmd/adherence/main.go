@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/adherence"
+	"github.com/jonsabados/mta2furious/mta/static"
+	"github.com/rs/zerolog"
+)
+
+// adherence reads completed segments as JSON lines (as written by watch's JSON lines sinks) and writes how each compared
+// to the static schedule, also as JSON lines
+func main() {
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+	var staticPath string
+	flag.StringVar(&staticPath, "static", "google_transit.zip", "path of the static GTFS zip, or a directory it has been extracted to")
+	var in string
+	flag.StringVar(&in, "segments", "", "path of a JSON lines file of segments, stdin if empty")
+	flag.Parse()
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		logger.Fatal().Err(err).Msg("unable to load time zone")
+	}
+	schedule, err := static.Load(staticPath)
+	if err != nil {
+		logger.Fatal().Err(err).Str("path", staticPath).Msg("unable to load static schedule")
+	}
+	analyzer := adherence.NewAnalyzer(schedule, loc)
+
+	var reader io.Reader = os.Stdin
+	if in != "" {
+		f, err := os.Open(in)
+		if err != nil {
+			logger.Fatal().Err(err).Str("path", in).Msg("unable to open segments")
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	encoder := json.NewEncoder(out)
+	decoder := json.NewDecoder(reader)
+	for {
+		var segment mta.Segment
+		err := decoder.Decode(&segment)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to read segment")
+		}
+		res, err := analyzer.Analyze(segment)
+		if err != nil {
+			logger.Warn().Err(err).Msg("unable to analyze segment")
+			continue
+		}
+		err = encoder.Encode(res)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to write result")
+		}
+	}
+}
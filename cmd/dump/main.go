@@ -14,8 +14,25 @@ func main() {
 	apiKey := os.Getenv("MTA_API_KEY")
 	var out string
 	flag.StringVar(&out, "out", "output.json", "output target")
+	var feedName string
+	flag.StringVar(&feedName, "feed", "ace", "name of the feed to dump")
+	var registryPath string
+	flag.StringVar(&registryPath, "registry", "", "path of a JSON file of feed definitions overriding or adding to the built in registry")
 	flag.Parse()
-	feed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-ace", apiKey)
+
+	registry := mta.DefaultFeedRegistry()
+	if registryPath != "" {
+		overrides, err := mta.LoadFeedDefinitions(registryPath)
+		if err != nil {
+			panic(err)
+		}
+		registry = registry.WithOverrides(overrides...)
+	}
+	feedDefs, err := registry.ByName(feedName)
+	if err != nil {
+		panic(err)
+	}
+	feed := mta.NewLiveFeeds(feedDefs, apiKey)[0]
 	currentState, err := feed.Feed(ctx)
 	if err != nil {
 		panic(err)
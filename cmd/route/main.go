@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/routing"
+	"github.com/jonsabados/mta2furious/mta/static"
+	"github.com/rs/zerolog"
+)
+
+// route finds the fastest way between two stops leaving now, riding the trains currently in the live feeds, and writes
+// it to stdout as JSON
+func main() {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	ctx = logger.WithContext(ctx)
+	apiKey := os.Getenv("MTA_API_KEY")
+
+	var from, to string
+	flag.StringVar(&from, "from", "", "stop ID to leave from, either a station (F27) or platform (F27N)")
+	flag.StringVar(&to, "to", "", "stop ID to go to, either a station (F27) or platform (F27N)")
+	var staticPath string
+	flag.StringVar(&staticPath, "static", "google_transit.zip", "path of the static GTFS zip, or a directory it has been extracted to")
+	var stationsPath string
+	flag.StringVar(&stationsPath, "stations", "", "path of the MTA's Stations.csv, allows transfers anywhere within a station complex if given")
+	var complexTransferTime time.Duration
+	flag.DurationVar(&complexTransferTime, "complexTransferTime", routing.DefaultComplexTransferTime, "time allowed for transfers within a station complex that transfers.txt doesn't cover, used with -stations")
+	var segmentsPath string
+	flag.StringVar(&segmentsPath, "segments", "", "path of a JSON lines file of observed segments (as written by watch) to refine run times with")
+	var routes string
+	flag.StringVar(&routes, "routes", "", "comma separated routes whose feeds are pulled, eg A,C,G. All trip feeds are pulled if empty")
+	var registryPath string
+	flag.StringVar(&registryPath, "registry", "", "path of a JSON file of feed definitions overriding or adding to the built in registry")
+	flag.Parse()
+
+	if from == "" || to == "" {
+		logger.Fatal().Msg("-from and -to are required")
+	}
+
+	schedule, err := static.Load(staticPath)
+	if err != nil {
+		logger.Fatal().Err(err).Str("path", staticPath).Msg("unable to load static schedule")
+	}
+	var graphOpts []routing.GraphOption
+	if stationsPath != "" {
+		stations, err := static.LoadStations(stationsPath)
+		if err != nil {
+			logger.Fatal().Err(err).Str("path", stationsPath).Msg("unable to load stations")
+		}
+		graphOpts = append(graphOpts, routing.WithComplexTransfers(static.NewStationIndex(schedule, stations), complexTransferTime))
+	}
+	graph := routing.NewGraph(schedule, graphOpts...)
+	if segmentsPath != "" {
+		segments, err := readSegments(segmentsPath)
+		if err != nil {
+			logger.Fatal().Err(err).Str("path", segmentsPath).Msg("unable to read segments")
+		}
+		graph.ObserveSegments(segments)
+	}
+
+	registry := mta.DefaultFeedRegistry()
+	if registryPath != "" {
+		overrides, err := mta.LoadFeedDefinitions(registryPath)
+		if err != nil {
+			logger.Fatal().Err(err).Str("path", registryPath).Msg("unable to load feed registry")
+		}
+		registry = registry.WithOverrides(overrides...)
+	}
+	var routeIDs []string
+	if routes != "" {
+		routeIDs = strings.Split(routes, ",")
+	}
+	feedDefs, err := registry.Select(nil, routeIDs)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("invalid feed selection")
+	}
+	state, err := mta.NewTransitSystem(mta.NewLiveFeeds(feedDefs, apiKey)).CurrentState(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("unable to pull feeds")
+	}
+
+	path, err := graph.FastestPath(from, to, time.Now(), state.TripUpdates)
+	if err != nil {
+		logger.Fatal().Err(err).Str("from", from).Str("to", to).Msg("unable to find a path")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(path)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("unable to write path")
+	}
+}
+
+func readSegments(path string) ([]mta.Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ret := make([]mta.Segment, 0)
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var segment mta.Segment
+		err := decoder.Decode(&segment)
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, segment)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything watch needs to run. It is assembled from defaults, then an optional YAML or JSON config file,
+// then environment variables, then any flags explicitly given on the command line.
+type Config struct {
+	APIKey  string        `yaml:"apiKey"`
+	Refresh time.Duration `yaml:"refresh"`
+	// ShutdownTimeout is how long an in-flight tick is given to finish once a shutdown is requested
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout"`
+	StoppedAtArrivals bool          `yaml:"stoppedAtArrivals"`
+	Feeds             FeedsConfig   `yaml:"feeds"`
+	Store             StoreConfig   `yaml:"store"`
+	Sinks             SinksConfig   `yaml:"sinks"`
+	Filters           FilterConfig  `yaml:"filters"`
+	Serve             ServeConfig   `yaml:"serve"`
+	Static            StaticConfig  `yaml:"static"`
+	Logging           LoggingConfig `yaml:"logging"`
+}
+
+type FeedsConfig struct {
+	// Names and Routes select feeds from the registry, if both are empty all trip feeds are used
+	Names  []string `yaml:"names"`
+	Routes []string `yaml:"routes"`
+	// Registry is the path of a JSON file of feed definitions overriding the built-in registry
+	Registry string `yaml:"registry"`
+	// Overrides are feed definitions overriding the built-in registry, applied after Registry
+	Overrides   []mta.FeedDefinition `yaml:"overrides"`
+	Concurrency int                  `yaml:"concurrency"`
+	Timeout     time.Duration        `yaml:"timeout"`
+}
+
+type StoreConfig struct {
+	// Type is one of memory, file or sqlite
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+type SinksConfig struct {
+	// Archive is the path of a sqlite database to archive segments to
+	Archive string `yaml:"archive"`
+	JSONL   string `yaml:"jsonl"`
+	CSV     string `yaml:"csv"`
+	Stdout  bool   `yaml:"stdout"`
+}
+
+// FilterConfig limits which segments are sent to sinks
+type FilterConfig struct {
+	Routes   []string `yaml:"routes"`
+	Stations []string `yaml:"stations"`
+}
+
+// ServeConfig controls serve mode, where the HTTP query API is exposed alongside the processing loop
+type ServeConfig struct {
+	// Listen is the address to serve the API on, eg :8080. Serve mode is disabled if empty.
+	Listen string `yaml:"listen"`
+}
+
+// StaticConfig points at static data used to enrich segments with station and route metadata
+type StaticConfig struct {
+	// GTFS is the path of the static GTFS zip, or a directory it has been extracted to. Enrichment is disabled if empty.
+	GTFS string `yaml:"gtfs"`
+	// Stations is the path of the MTA's Stations.csv, adding boroughs, structures and lines to station names. Optional.
+	Stations string `yaml:"stations"`
+}
+
+type LoggingConfig struct {
+	Level string `yaml:"level"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Refresh:         time.Second * 30,
+		ShutdownTimeout: time.Second * 45,
+		Feeds: FeedsConfig{
+			Concurrency: 4,
+			Timeout:     time.Second * 30,
+		},
+		Store: StoreConfig{
+			Type: "memory",
+			Path: "state.json",
+		},
+		Logging: LoggingConfig{
+			Level: "debug",
+		},
+	}
+}
+
+// loadConfig builds the config from the command line args and environment, returning an error describing every problem
+// found rather than just the first
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path of a YAML or JSON config file")
+	refresh := flags.Duration("refresh", cfg.Refresh, "refresh duration")
+	shutdownTimeout := flags.Duration("shutdownTimeout", cfg.ShutdownTimeout, "how long in-flight work is given to finish on shutdown")
+	storeType := flags.String("store", cfg.Store.Type, "state store backend, one of memory, file or sqlite")
+	storePath := flags.String("storePath", cfg.Store.Path, "path of the state file when using the file or sqlite store")
+	archivePath := flags.String("archive", "", "path of a sqlite database to archive completed segments to, disabled if empty")
+	jsonlPath := flags.String("jsonl", "", "path of a file to append completed segments to as JSON lines, disabled if empty")
+	csvPath := flags.String("csv", "", "path of a file to append completed segments to as CSV, disabled if empty")
+	toStdout := flags.Bool("stdout", false, "write completed segments to stdout as JSON lines")
+	concurrency := flags.Int("concurrency", cfg.Feeds.Concurrency, "maximum number of feeds to pull at once")
+	feedTimeout := flags.Duration("feedTimeout", cfg.Feeds.Timeout, "time limit for pulling a single feed, retries included")
+	feedNames := flags.String("feeds", "", "comma separated names of feeds to watch, eg ace,g")
+	routes := flags.String("routes", "", "comma separated routes to watch, eg A,C,G. All trip feeds are watched if neither -feeds or -routes is given")
+	registryPath := flags.String("registry", "", "path of a JSON file of feed definitions overriding or adding to the built in registry")
+	useStoppedAt := flags.Bool("stoppedAt", false, "use vehicle STOPPED_AT positions as actual arrival times")
+	filterRoutes := flags.String("filterRoutes", "", "comma separated routes, only segments on these routes are sent to sinks")
+	serveAddr := flags.String("serve", "", "address to serve the HTTP query API on, eg :8080. Disabled if empty")
+	staticPath := flags.String("static", "", "path of the static GTFS zip (or extracted directory) used to enrich segments, disabled if empty")
+	stationsPath := flags.String("stations", "", "path of the MTA's Stations.csv used to enrich segments, requires -static")
+	filterStations := flags.String("filterStations", "", "comma separated stop IDs, only segments touching these stops are sent to sinks")
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *configPath != "" {
+		b, err := os.ReadFile(*configPath)
+		if err != nil {
+			return Config{}, err
+		}
+		// YAML is a superset of JSON so this handles both
+		err = yaml.Unmarshal(b, &cfg)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", *configPath, err)
+		}
+	}
+
+	envErrs := applyEnv(&cfg, getenv)
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "refresh":
+			cfg.Refresh = *refresh
+		case "shutdownTimeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		case "store":
+			cfg.Store.Type = *storeType
+		case "storePath":
+			cfg.Store.Path = *storePath
+		case "archive":
+			cfg.Sinks.Archive = *archivePath
+		case "jsonl":
+			cfg.Sinks.JSONL = *jsonlPath
+		case "csv":
+			cfg.Sinks.CSV = *csvPath
+		case "stdout":
+			cfg.Sinks.Stdout = *toStdout
+		case "concurrency":
+			cfg.Feeds.Concurrency = *concurrency
+		case "feedTimeout":
+			cfg.Feeds.Timeout = *feedTimeout
+		case "feeds":
+			cfg.Feeds.Names = splitList(*feedNames)
+		case "routes":
+			cfg.Feeds.Routes = splitList(*routes)
+		case "registry":
+			cfg.Feeds.Registry = *registryPath
+		case "stoppedAt":
+			cfg.StoppedAtArrivals = *useStoppedAt
+		case "filterRoutes":
+			cfg.Filters.Routes = splitList(*filterRoutes)
+		case "filterStations":
+			cfg.Filters.Stations = splitList(*filterStations)
+		case "serve":
+			cfg.Serve.Listen = *serveAddr
+		case "static":
+			cfg.Static.GTFS = *staticPath
+		case "stations":
+			cfg.Static.Stations = *stationsPath
+		}
+	})
+
+	return cfg, errors.Join(append(envErrs, cfg.validate()...)...)
+}
+
+// applyEnv overrides config values with any environment variables that are set
+func applyEnv(cfg *Config, getenv func(string) string) []error {
+	errs := make([]error, 0)
+	if v := getenv("MTA_API_KEY"); v != "" {
+		cfg.APIKey = v
+	}
+	if v := getenv("LOG_LEVEL"); v != "" {
+		cfg.Logging.Level = v
+	}
+	if v := getenv("WATCH_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("WATCH_REFRESH: %w", err))
+		} else {
+			cfg.Refresh = d
+		}
+	}
+	if v := getenv("WATCH_STORE"); v != "" {
+		cfg.Store.Type = v
+	}
+	if v := getenv("WATCH_STORE_PATH"); v != "" {
+		cfg.Store.Path = v
+	}
+	if v := getenv("WATCH_FEEDS"); v != "" {
+		cfg.Feeds.Names = splitList(v)
+	}
+	if v := getenv("WATCH_ROUTES"); v != "" {
+		cfg.Feeds.Routes = splitList(v)
+	}
+	if v := getenv("WATCH_SERVE"); v != "" {
+		cfg.Serve.Listen = v
+	}
+	if v := getenv("WATCH_STATIC"); v != "" {
+		cfg.Static.GTFS = v
+	}
+	if v := getenv("WATCH_STATIONS"); v != "" {
+		cfg.Static.Stations = v
+	}
+	if v := getenv("WATCH_CONCURRENCY"); v != "" {
+		c, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("WATCH_CONCURRENCY: %w", err))
+		} else {
+			cfg.Feeds.Concurrency = c
+		}
+	}
+	return errs
+}
+
+func (c Config) validate() []error {
+	errs := make([]error, 0)
+	if c.Refresh <= 0 {
+		errs = append(errs, fmt.Errorf("refresh must be positive, got %s", c.Refresh))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdownTimeout must be positive, got %s", c.ShutdownTimeout))
+	}
+	if _, err := zerolog.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: %w", err))
+	}
+	switch c.Store.Type {
+	case "memory":
+	case "file", "sqlite":
+		if c.Store.Path == "" {
+			errs = append(errs, fmt.Errorf("store.path is required for the %s store", c.Store.Type))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("store.type must be one of memory, file or sqlite, got %q", c.Store.Type))
+	}
+	if c.Feeds.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("feeds.concurrency must be at least 1, got %d", c.Feeds.Concurrency))
+	}
+	if c.Feeds.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("feeds.timeout must not be negative, got %s", c.Feeds.Timeout))
+	}
+	if c.Static.Stations != "" && c.Static.GTFS == "" {
+		errs = append(errs, errors.New("static.stations requires static.gtfs"))
+	}
+	for i, o := range c.Feeds.Overrides {
+		if o.Name == "" {
+			errs = append(errs, fmt.Errorf("feeds.overrides[%d] has no name", i))
+		}
+	}
+	registry, err := c.registry()
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := registry.Select(c.Feeds.Names, c.Feeds.Routes); err != nil {
+		errs = append(errs, fmt.Errorf("feeds: %w", err))
+	}
+	return errs
+}
+
+// registry returns the built-in feed registry with any configured overrides applied
+func (c Config) registry() (*mta.FeedRegistry, error) {
+	registry := mta.DefaultFeedRegistry()
+	if c.Feeds.Registry != "" {
+		overrides, err := mta.LoadFeedDefinitions(c.Feeds.Registry)
+		if err != nil {
+			return nil, fmt.Errorf("feeds.registry: %w", err)
+		}
+		registry = registry.WithOverrides(overrides...)
+	}
+	return registry.WithOverrides(c.Feeds.Overrides...), nil
+}
+
+func (c Config) segmentFilter() mta.SegmentFilter {
+	return mta.SegmentFilter{
+		Routes:   c.Filters.Routes,
+		Stations: c.Filters.Stations,
+	}
+}
+
+func splitList(s string) []string {
+	ret := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
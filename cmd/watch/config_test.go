@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+	yamlPath := writeConfig("watch.yaml", `
+refresh: 15s
+feeds:
+  routes: [A, G]
+  overrides:
+    - name: g
+      url: http://localhost:8080/g
+store:
+  type: sqlite
+  path: state.db
+sinks:
+  jsonl: segments.jsonl
+filters:
+  stations: [F27]
+serve:
+  listen: ":8080"
+static:
+  gtfs: google_transit.zip
+  stations: Stations.csv
+logging:
+  level: info
+`)
+	jsonPath := writeConfig("watch.json", `{"refresh": "45s", "store": {"type": "file", "path": "state.json"}}`)
+	badPath := writeConfig("bad.yaml", `
+refresh: 0s
+feeds:
+  routes: [K]
+  concurrency: 0
+store:
+  type: redis
+static:
+  stations: Stations.csv
+logging:
+  level: chatty
+`)
+
+	noEnv := func(string) string {
+		return ""
+	}
+
+	testCases := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		expected      func() Config
+		expectedError []string
+	}{
+		{
+			name:     "defaults",
+			expected: defaultConfig,
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlPath},
+			expected: func() Config {
+				cfg := defaultConfig()
+				cfg.Refresh = time.Second * 15
+				cfg.Feeds.Routes = []string{"A", "G"}
+				cfg.Feeds.Overrides = []mta.FeedDefinition{{Name: "g", URL: "http://localhost:8080/g"}}
+				cfg.Store.Type = "sqlite"
+				cfg.Store.Path = "state.db"
+				cfg.Sinks.JSONL = "segments.jsonl"
+				cfg.Filters.Stations = []string{"F27"}
+				cfg.Serve.Listen = ":8080"
+				cfg.Static.GTFS = "google_transit.zip"
+				cfg.Static.Stations = "Stations.csv"
+				cfg.Logging.Level = "info"
+				return cfg
+			},
+		},
+		{
+			name: "json file",
+			args: []string{"-config", jsonPath},
+			expected: func() Config {
+				cfg := defaultConfig()
+				cfg.Refresh = time.Second * 45
+				cfg.Store.Type = "file"
+				return cfg
+			},
+		},
+		{
+			name: "env overrides file, flags override env",
+			args: []string{"-config", jsonPath, "-refresh", "5s", "-routes", "L"},
+			env: map[string]string{
+				"MTA_API_KEY":    "secret",
+				"WATCH_REFRESH":  "1m",
+				"WATCH_STORE":    "memory",
+				"WATCH_ROUTES":   "A,C",
+				"WATCH_STATIC":   "data/gtfs_subway.zip",
+				"WATCH_STATIONS": "data/Stations.csv",
+			},
+			expected: func() Config {
+				cfg := defaultConfig()
+				cfg.APIKey = "secret"
+				cfg.Refresh = time.Second * 5
+				cfg.Store.Type = "memory"
+				cfg.Feeds.Routes = []string{"L"}
+				cfg.Static.GTFS = "data/gtfs_subway.zip"
+				cfg.Static.Stations = "data/Stations.csv"
+				return cfg
+			},
+		},
+		{
+			name: "everything wrong is reported",
+			args: []string{"-config", badPath},
+			env: map[string]string{
+				"WATCH_CONCURRENCY": "lots",
+			},
+			expectedError: []string{
+				"WATCH_CONCURRENCY",
+				"refresh must be positive",
+				"logging.level",
+				`store.type must be one of memory, file or sqlite, got "redis"`,
+				"feeds.concurrency must be at least 1",
+				`no feed serves route "K"`,
+				"static.stations requires static.gtfs",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getenv := noEnv
+			if tc.env != nil {
+				getenv = func(k string) string {
+					return tc.env[k]
+				}
+			}
+			cfg, err := loadConfig(tc.args, getenv)
+			if len(tc.expectedError) > 0 {
+				require.Error(t, err)
+				for _, e := range tc.expectedError {
+					assert.ErrorContains(t, err, e)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected(), cfg)
+		})
+	}
+}
@@ -2,62 +2,286 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/api"
+	"github.com/jonsabados/mta2furious/mta/enrich"
+	"github.com/jonsabados/mta2furious/mta/sqlstore"
+	"github.com/jonsabados/mta2furious/mta/static"
 	"github.com/rs/zerolog"
 )
 
+// finalFlushTimeout bounds recording the final state on shutdown. It gets a context of its own so it still happens when
+// in-flight work was cancelled for overrunning the shutdown timeout.
+const finalFlushTimeout = time.Second * 5
+
 func main() {
 	ctx := context.Background()
+	// logs go to stderr so stdout is left to the stdout sink, where it is nothing but segments
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
 
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	if logLevelStr == "" {
-		logLevelStr = "debug"
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	logLevel, err := zerolog.ParseLevel(logLevelStr)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("invalid log level")
+		logger.Fatal().Err(err).Msg("invalid configuration")
 	}
-	logger = logger.Level(logLevel)
 
+	// validated by loadConfig
+	logLevel, _ := zerolog.ParseLevel(cfg.Logging.Level)
+	logger = logger.Level(logLevel)
 	ctx = logger.WithContext(ctx)
-	apiKey := os.Getenv("MTA_API_KEY")
-	var out string
-	flag.StringVar(&out, "out", "output.json", "output target")
-	var refreshRate time.Duration
-	flag.DurationVar(&refreshRate, "refresh", time.Second*30, "refresh duration")
-	flag.Parse()
-
-	afeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-ace", apiKey)
-	bfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-bdfm", apiKey)
-	gfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-g", apiKey)
-	jfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-jz", apiKey)
-	nfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-nqrw", apiKey)
-	lfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-l", apiKey)
-	numberedFeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs", apiKey)
-	sfeed := mta.NewLiveFeed("https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-si", apiKey)
-
-	transitSystem := mta.NewTransitSystem(afeed, bfeed, gfeed, jfeed, nfeed, lfeed, numberedFeed, sfeed)
-	store := mta.NewMemoryStore()
-	processor := mta.NewStateProcessor(transitSystem, store)
-
-	ticker := time.Tick(refreshRate)
-	_, err = processor.ProcessUpdates(ctx)
+
+	err = run(ctx, cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("watch failed")
+	}
+	logger.Info().Msg("shutdown complete")
+}
+
+// run watches the configured feeds until SIGINT or SIGTERM. Errors are returned rather than exiting so that whatever
+// has been opened by then is closed on the way out.
+func run(ctx context.Context, cfg Config) error {
+	logger := zerolog.Ctx(ctx)
+
+	registry, err := cfg.registry()
 	if err != nil {
-		logger.Err(err).Msg("error encountered on initial pull")
+		return fmt.Errorf("unable to load feed registry: %w", err)
+	}
+	feedDefs, err := registry.Select(cfg.Feeds.Names, cfg.Feeds.Routes)
+	if err != nil {
+		return fmt.Errorf("invalid feed selection: %w", err)
+	}
+	logger.Info().Strs("feeds", feedNamesOf(feedDefs)).Msg("watching feeds")
+
+	transitSystem := mta.NewTransitSystem(
+		mta.NewLiveFeeds(feedDefs, cfg.APIKey),
+		mta.WithConcurrency(cfg.Feeds.Concurrency),
+		mta.WithFeedTimeout(cfg.Feeds.Timeout),
+	)
+	var store mta.StateStore
+	switch cfg.Store.Type {
+	case "memory":
+		store = mta.NewMemoryStore()
+	case "file":
+		store = mta.NewFileStore(cfg.Store.Path)
+	case "sqlite":
+		db, err := sqlstore.Open(ctx, cfg.Store.Path)
+		if err != nil {
+			return fmt.Errorf("unable to open state database %s: %w", cfg.Store.Path, err)
+		}
+		defer db.Close()
+		store = sqlstore.NewStore(db)
+	}
+	var processorOpts []mta.StateProcessorOption
+	if cfg.StoppedAtArrivals {
+		processorOpts = append(processorOpts, mta.WithStoppedAtArrivals())
+	}
+	if cfg.Static.GTFS != "" {
+		enricher, err := loadEnricher(cfg.Static)
+		if err != nil {
+			return fmt.Errorf("unable to load static data: %w", err)
+		}
+		processorOpts = append(processorOpts, mta.WithSegmentEnricher(enricher))
+	}
+	processor := mta.NewStateProcessor(transitSystem, store, processorOpts...)
+
+	sinks := make([]mta.SegmentSink, 0)
+	// until the sinks are handed off to the combined sink below, close whatever was opened if a later one fails
+	closeSinks := func() {
+		_ = mta.NewMultiSink(sinks...).Close()
+	}
+	if cfg.Sinks.Archive != "" {
+		db, err := sqlstore.Open(ctx, cfg.Sinks.Archive)
+		if err != nil {
+			return fmt.Errorf("unable to open segment archive %s: %w", cfg.Sinks.Archive, err)
+		}
+		defer db.Close()
+		sinks = append(sinks, sqlstore.NewSegmentArchive(db))
+	}
+	if cfg.Sinks.JSONL != "" {
+		sink, err := mta.NewJSONLinesFileSink(cfg.Sinks.JSONL)
+		if err != nil {
+			closeSinks()
+			return fmt.Errorf("unable to open JSON lines sink %s: %w", cfg.Sinks.JSONL, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Sinks.CSV != "" {
+		sink, err := mta.NewCSVFileSink(cfg.Sinks.CSV)
+		if err != nil {
+			closeSinks()
+			return fmt.Errorf("unable to open CSV sink %s: %w", cfg.Sinks.CSV, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Sinks.Stdout {
+		sinks = append(sinks, mta.NewStdoutSink())
+	}
+	sink := mta.NewFilteredSink(mta.NewMultiSink(sinks...), cfg.segmentFilter())
+	defer func() {
+		err := sink.Close()
+		if err != nil {
+			logger.Err(err).Msg("error closing segment sinks")
+		}
+	}()
+
+	var apiServer *api.Server
+	var httpServer *http.Server
+	if cfg.Serve.Listen != "" {
+		// listen up front so a bad address fails at startup rather than in the background
+		listener, err := net.Listen("tcp", cfg.Serve.Listen)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %w", cfg.Serve.Listen, err)
+		}
+		apiServer = api.NewServer()
+		httpServer = &http.Server{
+			Handler:           apiServer,
+			ReadHeaderTimeout: time.Second * 10,
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
+		}
+		// streams never finish on their own, so end them when shutting down
+		httpServer.RegisterOnShutdown(apiServer.Close)
+		go func() {
+			err := httpServer.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				logger.Err(err).Msg("API server failed")
+			}
+		}()
+		logger.Info().Str("address", listener.Addr().String()).Msg("serving API")
 	}
-	for range ticker {
+
+	// shutdownCtx is cancelled on SIGINT or SIGTERM, at which point no new ticks start. Work is done with workCtx, which is
+	// only cancelled if an in-flight tick doesn't finish within the shutdown timeout, so a tick interrupted by a signal
+	// still gets to record its state and hand its segments to the sinks.
+	shutdownCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	workCtx, cancelWork := context.WithCancel(ctx)
+	defer cancelWork()
+	tickDone := make(chan struct{})
+	go func() {
+		<-shutdownCtx.Done()
+		// restore default signal handling so a second signal kills the process outright
+		stopSignals()
+		logger.Info().Msg("shutdown requested, waiting for in-flight work to finish")
+		select {
+		case <-tickDone:
+		case <-time.After(cfg.ShutdownTimeout):
+			logger.Warn().Dur("timeout", cfg.ShutdownTimeout).Msg("in-flight work did not finish in time, cancelling")
+			cancelWork()
+		}
+	}()
+
+	process := func() {
+		ctx := workCtx
 		result, err := processor.ProcessUpdates(ctx)
 		if err != nil {
 			logger.Err(err).Msg("error encountered")
-			continue
+			if apiServer != nil {
+				apiServer.UpdateFailed(err)
+			}
+			return
+		}
+		if apiServer != nil {
+			apiServer.Update(result)
 		}
 		for _, segment := range result.CompletedSegments {
 			logger.Info().Interface("segment", segment).Msg("a segment completed")
 		}
+		for _, visit := range result.StopVisits {
+			logger.Debug().
+				Str("tripID", visit.TripID).
+				Str("stopID", visit.StopID).
+				Dur("dwell", visit.Dwell).
+				Bool("arrivalObserved", visit.ArrivalObserved).
+				Msg("a stop visit completed")
+		}
+		for _, trip := range result.CompletedTrips {
+			logger.Info().
+				Str("tripID", trip.TripID).
+				Str("routeID", trip.RouteID).
+				Str("origin", trip.Origin).
+				Str("terminus", trip.Terminus).
+				Dur("duration", trip.Duration()).
+				Int("trackChanges", trip.TrackChanges).
+				Bool("hasInferredStops", trip.HasInferredStops).
+				Msg("a trip completed")
+		}
+		if len(result.CompletedSegments) > 0 {
+			// failures are logged per sink by the multi sink, nothing more to do with them here
+			_ = sink.Write(ctx, result.CompletedSegments)
+		}
+	}
+
+	ticker := time.NewTicker(cfg.Refresh)
+	process()
+loop:
+	for {
+		select {
+		case <-shutdownCtx.Done():
+			break loop
+		case <-ticker.C:
+			// a tick can race with the shutdown signal, prefer shutting down
+			if shutdownCtx.Err() != nil {
+				break loop
+			}
+			process()
+		}
+	}
+	ticker.Stop()
+	close(tickDone)
+
+	flushCtx, cancelFlush := context.WithTimeout(ctx, finalFlushTimeout)
+	err = processor.Flush(flushCtx)
+	cancelFlush()
+	if err != nil {
+		logger.Err(err).Msg("error recording final state")
+	}
+
+	if httpServer != nil {
+		shutdownHTTPCtx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+		err = httpServer.Shutdown(shutdownHTTPCtx)
+		cancel()
+		if err != nil {
+			logger.Err(err).Msg("error shutting down API server")
+		}
+	}
+
+	return nil
+}
+
+func loadEnricher(cfg StaticConfig) (*enrich.Enricher, error) {
+	schedule, err := static.Load(cfg.GTFS)
+	if err != nil {
+		return nil, fmt.Errorf("static.gtfs: %w", err)
+	}
+	var stations *static.StationIndex
+	if cfg.Stations != "" {
+		records, err := static.LoadStations(cfg.Stations)
+		if err != nil {
+			return nil, fmt.Errorf("static.stations: %w", err)
+		}
+		stations = static.NewStationIndex(schedule, records)
+	}
+	return enrich.NewEnricher(schedule, stations), nil
+}
+
+func feedNamesOf(defs []mta.FeedDefinition) []string {
+	ret := make([]string, len(defs))
+	for i, d := range defs {
+		ret[i] = d.Name
 	}
+	return ret
 }
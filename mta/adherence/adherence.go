@@ -0,0 +1,93 @@
+package adherence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+)
+
+var (
+	// ErrNoScheduledTrip is returned when no scheduled trip running on the segment's service day matches its trip ID
+	ErrNoScheduledTrip = errors.New("no scheduled trip found")
+	// ErrStopsNotScheduled is returned when the matching scheduled trip doesn't serve both ends of the segment
+	ErrStopsNotScheduled = errors.New("segment stops are not on the scheduled trip")
+)
+
+// SegmentAdherence compares an observed segment to the schedule of the trip it was run as
+type SegmentAdherence struct {
+	Segment         mta.Segment `json:"segment"`
+	ScheduledTripID string      `json:"scheduledTripId"`
+	ServiceDate     string      `json:"serviceDate"`
+	ScheduledDepart time.Time   `json:"scheduledDepart"`
+	ScheduledArrive time.Time   `json:"scheduledArrive"`
+	// DepartDelay is how late the train left the from station, negative if it left early
+	DepartDelay time.Duration `json:"departDelay"`
+	// ArriveDelay is how late the train reached the to station, negative if it arrived early
+	ArriveDelay time.Duration `json:"arriveDelay"`
+	// RunTimeDelta is how much longer the segment took to run than scheduled, negative if it was quicker
+	RunTimeDelta time.Duration `json:"runTimeDelta"`
+}
+
+// Analyzer matches observed segments to the static schedule
+type Analyzer struct {
+	loc      *time.Location
+	schedule *static.Schedule
+	matcher  *static.TripMatcher
+}
+
+// NewAnalyzer matches against the given schedule, times are interpreted in loc which should be America/New_York for
+// NYCT
+func NewAnalyzer(schedule *static.Schedule, loc *time.Location) *Analyzer {
+	return &Analyzer{
+		loc:      loc,
+		schedule: schedule,
+		matcher:  schedule.TripMatcher(),
+	}
+}
+
+// Analyze finds the scheduled trip the segment was run as and reports how it compared
+func (a *Analyzer) Analyze(segment mta.Segment) (SegmentAdherence, error) {
+	id, err := static.ParseTripID(segment.TripID)
+	if err != nil {
+		return SegmentAdherence{}, fmt.Errorf("%w: %w", ErrNoScheduledTrip, err)
+	}
+	day := id.ServiceDay(segment.DepartAt, a.loc)
+	trip, err := a.matcher.Match(id, day)
+	if err != nil {
+		return SegmentAdherence{}, fmt.Errorf("%w: trip %s on %s", ErrNoScheduledTrip, segment.TripID, day)
+	}
+	from, to, ok := a.scheduledStops(trip.TripID, segment.FromStation, segment.ToStation)
+	if !ok {
+		return SegmentAdherence{}, fmt.Errorf("%w: trip %s from %s to %s", ErrStopsNotScheduled, segment.TripID, segment.FromStation, segment.ToStation)
+	}
+	ret := SegmentAdherence{
+		Segment:         segment,
+		ScheduledTripID: trip.TripID,
+		ServiceDate:     day.String(),
+		ScheduledDepart: from.DepartureTime.On(day, a.loc),
+		ScheduledArrive: to.ArrivalTime.On(day, a.loc),
+	}
+	ret.DepartDelay = segment.DepartAt.Sub(ret.ScheduledDepart)
+	ret.ArriveDelay = segment.ArriveAt.Sub(ret.ScheduledArrive)
+	ret.RunTimeDelta = segment.ArriveAt.Sub(segment.DepartAt) - ret.ScheduledArrive.Sub(ret.ScheduledDepart)
+	return ret, nil
+}
+
+// scheduledStops finds the from and to stops on the scheduled trip, to must come after from
+func (a *Analyzer) scheduledStops(tripID, fromStop, toStop string) (static.StopTime, static.StopTime, bool) {
+	var from *static.StopTime
+	for _, st := range a.schedule.StopTimesFor(tripID) {
+		st := st
+		if from == nil && st.StopID == fromStop {
+			from = &st
+			continue
+		}
+		if from != nil && st.StopID == toStop {
+			return *from, st, true
+		}
+	}
+	return static.StopTime{}, static.StopTime{}, false
+}
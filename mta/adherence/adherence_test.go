@@ -0,0 +1,108 @@
+package adherence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtures = "../static/fixtures"
+
+func testAnalyzer(t *testing.T) (*Analyzer, *time.Location) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	schedule, err := static.Load(fixtures)
+	require.NoError(t, err)
+	return NewAnalyzer(schedule, loc), loc
+}
+
+func TestAnalyzer_Analyze(t *testing.T) {
+	analyzer, loc := testAnalyzer(t)
+
+	testCases := []struct {
+		name          string
+		segment       mta.Segment
+		expected      SegmentAdherence
+		expectedError error
+	}{
+		{
+			name: "late",
+			segment: mta.Segment{
+				FromStation: "101S",
+				ToStation:   "103S",
+				DepartAt:    time.Date(2023, 7, 23, 0, 7, 0, 0, loc),
+				ArriveAt:    time.Date(2023, 7, 23, 0, 8, 0, 0, loc),
+				TripID:      "000600_1..S",
+				RouteID:     "1",
+			},
+			expected: SegmentAdherence{
+				ScheduledTripID: "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+				ServiceDate:     "20230723",
+				ScheduledDepart: time.Date(2023, 7, 23, 0, 6, 0, 0, loc),
+				ScheduledArrive: time.Date(2023, 7, 23, 0, 7, 30, 0, loc),
+				DepartDelay:     time.Minute,
+				ArriveDelay:     time.Second * 30,
+				RunTimeDelta:    -time.Second * 30,
+			},
+		},
+		{
+			// the trip belongs to sundays service but runs into monday morning
+			name: "past midnight",
+			segment: mta.Segment{
+				FromStation: "104N",
+				ToStation:   "103N",
+				DepartAt:    time.Date(2023, 7, 23, 23, 58, 0, 0, loc),
+				ArriveAt:    time.Date(2023, 7, 24, 0, 1, 0, 0, loc),
+				TripID:      "143850_1..N",
+				RouteID:     "1",
+			},
+			expected: SegmentAdherence{
+				ScheduledTripID: "ASP23GEN-1037-Sunday-00_143850_1..N03R",
+				ServiceDate:     "20230723",
+				ScheduledDepart: time.Date(2023, 7, 23, 23, 58, 30, 0, loc),
+				ScheduledArrive: time.Date(2023, 7, 24, 0, 0, 30, 0, loc),
+				DepartDelay:     -time.Second * 30,
+				ArriveDelay:     time.Second * 30,
+				RunTimeDelta:    time.Minute,
+			},
+		},
+		{
+			name: "not running that day",
+			segment: mta.Segment{
+				FromStation: "101S",
+				ToStation:   "103S",
+				DepartAt:    time.Date(2023, 7, 25, 0, 7, 0, 0, loc),
+				ArriveAt:    time.Date(2023, 7, 25, 0, 8, 0, 0, loc),
+				TripID:      "000600_1..S",
+			},
+			expectedError: ErrNoScheduledTrip,
+		},
+		{
+			name: "wrong stops",
+			segment: mta.Segment{
+				FromStation: "103S",
+				ToStation:   "101S",
+				DepartAt:    time.Date(2023, 7, 23, 0, 7, 0, 0, loc),
+				ArriveAt:    time.Date(2023, 7, 23, 0, 8, 0, 0, loc),
+				TripID:      "000600_1..S",
+			},
+			expectedError: ErrStopsNotScheduled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := analyzer.Analyze(tc.segment)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			tc.expected.Segment = tc.segment
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
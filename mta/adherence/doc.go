@@ -0,0 +1,4 @@
+package adherence
+
+// Schedule adherence: matches observed segments to the trips they were scheduled as in the static GTFS data and
+// reports how late (or early) they ran.
@@ -0,0 +1,209 @@
+package mta
+
+import (
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta/wire"
+)
+
+type AlertCause string
+
+const (
+	CauseUnknown          AlertCause = "UNKNOWN_CAUSE"
+	CauseOther            AlertCause = "OTHER_CAUSE"
+	CauseTechnicalProblem AlertCause = "TECHNICAL_PROBLEM"
+	CauseStrike           AlertCause = "STRIKE"
+	CauseDemonstration    AlertCause = "DEMONSTRATION"
+	CauseAccident         AlertCause = "ACCIDENT"
+	CauseHoliday          AlertCause = "HOLIDAY"
+	CauseWeather          AlertCause = "WEATHER"
+	CauseMaintenance      AlertCause = "MAINTENANCE"
+	CauseConstruction     AlertCause = "CONSTRUCTION"
+	CausePoliceActivity   AlertCause = "POLICE_ACTIVITY"
+	CauseMedicalEmergency AlertCause = "MEDICAL_EMERGENCY"
+)
+
+type AlertEffect string
+
+const (
+	EffectNoService         AlertEffect = "NO_SERVICE"
+	EffectReducedService    AlertEffect = "REDUCED_SERVICE"
+	EffectSignificantDelays AlertEffect = "SIGNIFICANT_DELAYS"
+	EffectDetour            AlertEffect = "DETOUR"
+	EffectAdditionalService AlertEffect = "ADDITIONAL_SERVICE"
+	EffectModifiedService   AlertEffect = "MODIFIED_SERVICE"
+	EffectOther             AlertEffect = "OTHER_EFFECT"
+	EffectUnknown           AlertEffect = "UNKNOWN_EFFECT"
+	EffectStopMoved         AlertEffect = "STOP_MOVED"
+)
+
+type Translation struct {
+	Text string `json:"text"`
+	// BCP-47 language code, may be empty if there is only one translation
+	Language string `json:"language,omitempty"`
+}
+
+type TranslatedString []Translation
+
+// Text returns the translation for the given language, falling back to a translation without a language and then to
+// the first translation present
+func (t TranslatedString) Text(language string) string {
+	if len(t) == 0 {
+		return ""
+	}
+	for _, tr := range t {
+		if tr.Language == language {
+			return tr.Text
+		}
+	}
+	for _, tr := range t {
+		if tr.Language == "" {
+			return tr.Text
+		}
+	}
+	return t[0].Text
+}
+
+// EntitySelector identifies what an alert applies to. Empty fields are not part of the selection, for example a
+// selector with only RouteID set applies to the entire route.
+type EntitySelector struct {
+	AgencyID  string `json:"agencyId,omitempty"`
+	RouteID   string `json:"routeId,omitempty"`
+	RouteType *int32 `json:"routeType,omitempty"`
+	TripID    string `json:"tripId,omitempty"`
+	StopID    string `json:"stopId,omitempty"`
+}
+
+type Alert struct {
+	ID string `json:"id"`
+	// Time ranges the alert is active for, if empty the alert is active for as long as it is in the feed
+	ActivePeriod    []TimeRange      `json:"activePeriod,omitempty"`
+	InformedEntity  []EntitySelector `json:"informedEntity,omitempty"`
+	Cause           AlertCause       `json:"cause"`
+	Effect          AlertEffect      `json:"effect"`
+	URL             TranslatedString `json:"url,omitempty"`
+	HeaderText      TranslatedString `json:"headerText,omitempty"`
+	DescriptionText TranslatedString `json:"descriptionText,omitempty"`
+}
+
+// ActiveAt reports if the alert is in effect at the given time
+func (a Alert) ActiveAt(t time.Time) bool {
+	if len(a.ActivePeriod) == 0 {
+		return true
+	}
+	for _, p := range a.ActivePeriod {
+		if p.Start != nil && t.Before(*p.Start) {
+			continue
+		}
+		if p.End != nil && t.After(*p.End) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// AppliesToSegment reports if the alert was active while the segment was being travelled, and informs on the segment's
+// trip, route or either of its stations. Stop IDs are matched with and without the N/S direction suffix.
+func (a Alert) AppliesToSegment(s Segment) bool {
+	if !a.ActiveAt(s.DepartAt) && !a.ActiveAt(s.ArriveAt) {
+		return false
+	}
+	for _, e := range a.InformedEntity {
+		if e.matches(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e EntitySelector) matches(s Segment) bool {
+	if e.RouteID == "" && e.TripID == "" && e.StopID == "" {
+		// agency or route type wide selectors are not useful for correlation
+		return false
+	}
+	if e.RouteID != "" && e.RouteID != s.RouteID {
+		return false
+	}
+	if e.TripID != "" && e.TripID != s.TripID {
+		return false
+	}
+	if e.StopID != "" && !SameStop(e.StopID, s.FromStation) && !SameStop(e.StopID, s.ToStation) {
+		return false
+	}
+	return true
+}
+
+// SameStop compares stop IDs where either side may be a parent stop (F27) or a directional platform (F27N). A parent
+// matches both its platforms, but opposite platforms (F27N, F27S) do not match each other.
+func SameStop(a, b string) bool {
+	return a == b || trimDirection(a) == b || a == trimDirection(b)
+}
+
+func trimDirection(stopID string) string {
+	if l := len(stopID); l > 0 && (stopID[l-1] == 'N' || stopID[l-1] == 'S') {
+		return stopID[:l-1]
+	}
+	return stopID
+}
+
+func convertAlerts(raw []*wire.FeedEntity) []Alert {
+	ret := make([]Alert, 0)
+	for _, r := range raw {
+		if r.Alert == nil {
+			continue
+		}
+		ret = append(ret, convertAlert(r.GetId(), r.Alert))
+	}
+	return ret
+}
+
+func convertAlert(id string, raw *wire.Alert) Alert {
+	periods := make([]TimeRange, 0, len(raw.ActivePeriod))
+	for _, p := range raw.ActivePeriod {
+		if p == nil {
+			continue
+		}
+		periods = append(periods, TimeRange{
+			Start: convertTime(p.Start),
+			End:   convertTime(p.End),
+		})
+	}
+	entities := make([]EntitySelector, 0, len(raw.InformedEntity))
+	for _, e := range raw.InformedEntity {
+		if e == nil {
+			continue
+		}
+		entities = append(entities, EntitySelector{
+			AgencyID:  e.GetAgencyId(),
+			RouteID:   e.GetRouteId(),
+			RouteType: e.RouteType,
+			TripID:    e.GetTrip().GetTripId(),
+			StopID:    e.GetStopId(),
+		})
+	}
+	return Alert{
+		ID:              id,
+		ActivePeriod:    periods,
+		InformedEntity:  entities,
+		Cause:           AlertCause(raw.GetCause().String()),
+		Effect:          AlertEffect(raw.GetEffect().String()),
+		URL:             convertTranslatedString(raw.Url),
+		HeaderText:      convertTranslatedString(raw.HeaderText),
+		DescriptionText: convertTranslatedString(raw.DescriptionText),
+	}
+}
+
+func convertTranslatedString(raw *wire.TranslatedString) TranslatedString {
+	if raw == nil {
+		return nil
+	}
+	ret := make(TranslatedString, len(raw.Translation))
+	for i, t := range raw.Translation {
+		ret[i] = Translation{
+			Text:     t.GetText(),
+			Language: t.GetLanguage(),
+		}
+	}
+	return ret
+}
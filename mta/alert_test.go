@@ -0,0 +1,178 @@
+package mta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta/wire"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConvertAlerts(t *testing.T) {
+	raw := []*wire.FeedEntity{
+		{
+			Id: proto.String("lmm:planned_work:1234"),
+			Alert: &wire.Alert{
+				ActivePeriod: []*wire.TimeRange{
+					{Start: proto.Uint64(1689868800), End: proto.Uint64(1689890400)},
+				},
+				InformedEntity: []*wire.EntitySelector{
+					{AgencyId: proto.String("MTASBWY"), RouteId: proto.String("G")},
+					{StopId: proto.String("F26N")},
+					{Trip: &wire.TripDescriptor{TripId: proto.String("084421_G..N")}},
+				},
+				Cause:  wire.Alert_MAINTENANCE.Enum(),
+				Effect: wire.Alert_SIGNIFICANT_DELAYS.Enum(),
+				HeaderText: &wire.TranslatedString{
+					Translation: []*wire.TranslatedString_Translation{
+						{Text: proto.String("G trains are running with delays"), Language: proto.String("en")},
+						{Text: proto.String("<p>G trains are running with delays</p>"), Language: proto.String("en-html")},
+					},
+				},
+			},
+		},
+		{
+			Id:         proto.String("not-an-alert"),
+			TripUpdate: &wire.TripUpdate{Trip: &wire.TripDescriptor{TripId: proto.String("084421_G..N")}},
+		},
+		{
+			// no cause or effect set so proto defaults apply
+			Id:    proto.String("bare"),
+			Alert: &wire.Alert{},
+		},
+	}
+
+	start := time.Unix(1689868800, 0)
+	end := time.Unix(1689890400, 0)
+	expected := []Alert{
+		{
+			ID:           "lmm:planned_work:1234",
+			ActivePeriod: []TimeRange{{Start: &start, End: &end}},
+			InformedEntity: []EntitySelector{
+				{AgencyID: "MTASBWY", RouteID: "G"},
+				{StopID: "F26N"},
+				{TripID: "084421_G..N"},
+			},
+			Cause:  CauseMaintenance,
+			Effect: EffectSignificantDelays,
+			HeaderText: TranslatedString{
+				{Text: "G trains are running with delays", Language: "en"},
+				{Text: "<p>G trains are running with delays</p>", Language: "en-html"},
+			},
+		},
+		{
+			ID:             "bare",
+			ActivePeriod:   []TimeRange{},
+			InformedEntity: []EntitySelector{},
+			Cause:          CauseUnknown,
+			Effect:         EffectUnknown,
+		},
+	}
+	got := convertAlerts(raw)
+	assert.Equal(t, expected, got)
+	assert.Equal(t, "<p>G trains are running with delays</p>", got[0].HeaderText.Text("en-html"))
+	assert.Equal(t, "G trains are running with delays", got[0].HeaderText.Text("es"))
+}
+
+func TestAlert_AppliesToSegment(t *testing.T) {
+	start := time.Date(2023, 7, 20, 14, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC)
+	segment := Segment{
+		FromStation: "F27N",
+		ToStation:   "F26N",
+		DepartAt:    time.Date(2023, 7, 20, 14, 4, 11, 0, time.UTC),
+		ArriveAt:    time.Date(2023, 7, 20, 14, 6, 15, 0, time.UTC),
+		TripID:      "084421_G..N",
+		RouteID:     "G",
+	}
+
+	testCases := []struct {
+		name     string
+		alert    Alert
+		expected bool
+	}{
+		{
+			name:     "route match",
+			alert:    Alert{InformedEntity: []EntitySelector{{RouteID: "G"}}},
+			expected: true,
+		},
+		{
+			name:     "other route",
+			alert:    Alert{InformedEntity: []EntitySelector{{RouteID: "A"}}},
+			expected: false,
+		},
+		{
+			name:     "parent stop match",
+			alert:    Alert{InformedEntity: []EntitySelector{{StopID: "F26"}}},
+			expected: true,
+		},
+		{
+			name:     "platform match",
+			alert:    Alert{InformedEntity: []EntitySelector{{StopID: "F26N"}}},
+			expected: true,
+		},
+		{
+			name:     "opposite platform",
+			alert:    Alert{InformedEntity: []EntitySelector{{StopID: "F26S"}}},
+			expected: false,
+		},
+		{
+			name:     "route and stop must both match",
+			alert:    Alert{InformedEntity: []EntitySelector{{RouteID: "G", StopID: "A02"}}},
+			expected: false,
+		},
+		{
+			name:     "trip match",
+			alert:    Alert{InformedEntity: []EntitySelector{{TripID: "084421_G..N"}}},
+			expected: true,
+		},
+		{
+			name:     "agency wide",
+			alert:    Alert{InformedEntity: []EntitySelector{{AgencyID: "MTASBWY"}}},
+			expected: false,
+		},
+		{
+			name: "active during segment",
+			alert: Alert{
+				ActivePeriod:   []TimeRange{{Start: &start, End: &end}},
+				InformedEntity: []EntitySelector{{RouteID: "G"}},
+			},
+			expected: true,
+		},
+		{
+			name: "not yet active",
+			alert: Alert{
+				ActivePeriod:   []TimeRange{{Start: &end}},
+				InformedEntity: []EntitySelector{{RouteID: "G"}},
+			},
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.alert.AppliesToSegment(segment))
+		})
+	}
+}
+
+func TestSameStop(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{a: "F27N", b: "F27N", expected: true},
+		{a: "F27", b: "F27N", expected: true},
+		{a: "F27S", b: "F27", expected: true},
+		{a: "F27", b: "F27", expected: true},
+		// opposite platforms of the same station are different stops
+		{a: "F27N", b: "F27S", expected: false},
+		{a: "F27N", b: "F26N", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.expected, SameStop(tc.a, tc.b))
+		})
+	}
+}
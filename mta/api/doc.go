@@ -0,0 +1,5 @@
+package api
+
+// HTTP query API over the live state tracked by a StateProcessor: the trips currently in flight, recently completed
+// segments and the health of each feed. Everything is plain JSON over GET requests, with segments and trip lifecycle
+// events also pushed as they happen over a Server-Sent Events stream.
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/rs/zerolog"
+)
+
+// FeedHealth describes how pulling a feed has been going
+type FeedHealth struct {
+	Name string `json:"name"`
+	// LastSuccess is when the feed was last pulled without error, nil if it never has been
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastAttempt time.Time  `json:"lastAttempt"`
+	Stale       bool       `json:"stale"`
+	Error       string     `json:"error,omitempty"`
+	Healthy     bool       `json:"healthy"`
+}
+
+// StoreHealth describes how loading and recording trip state has been going, independent of the feeds
+type StoreHealth struct {
+	// LastSuccess is when state was last loaded and recorded without error, nil if it never has been
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastAttempt time.Time  `json:"lastAttempt"`
+	Error       string     `json:"error,omitempty"`
+	Healthy     bool       `json:"healthy"`
+}
+
+type healthResponse struct {
+	Status     string       `json:"status"`
+	LastUpdate *time.Time   `json:"lastUpdate,omitempty"`
+	Feeds      []FeedHealth `json:"feeds"`
+	Store      *StoreHealth `json:"store,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the in-flight trips, recently completed segments and feed health over HTTP. It is kept current by
+// passing it the results of each StateProcessor run via Update.
+type Server struct {
+	mutex       sync.RWMutex
+	trips       []mta.TripUpdate
+	segments    []mta.Segment
+	maxSegments int
+	feeds       []FeedHealth
+	store       *StoreHealth
+	lastUpdate  *time.Time
+	now         func() time.Time
+	mux         *http.ServeMux
+
+	subMutex     sync.Mutex
+	subscribers  map[*subscriber]struct{}
+	closed       bool
+	streamBuffer int
+	keepAlive    time.Duration
+}
+
+type ServerOption func(s *Server)
+
+// WithStreamBuffer sets how many events a stream subscriber may fall behind by before being disconnected, defaults to
+// 256
+func WithStreamBuffer(size int) ServerOption {
+	return func(s *Server) {
+		s.streamBuffer = size
+	}
+}
+
+// WithKeepAlive sets how often an idle stream is sent a comment to keep proxies from closing it, defaults to 15 seconds
+func WithKeepAlive(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.keepAlive = interval
+	}
+}
+
+// WithSegmentRetention sets how many of the most recently completed segments are kept for querying, defaults to 10000
+func WithSegmentRetention(maxSegments int) ServerOption {
+	return func(s *Server) {
+		s.maxSegments = maxSegments
+	}
+}
+
+func NewServer(opts ...ServerOption) *Server {
+	ret := &Server{
+		trips:       make([]mta.TripUpdate, 0),
+		segments:    make([]mta.Segment, 0),
+		maxSegments: 10000,
+		feeds:       make([]FeedHealth, 0),
+		now:         time.Now,
+
+		subscribers:  make(map[*subscriber]struct{}),
+		streamBuffer: 256,
+		keepAlive:    time.Second * 15,
+	}
+	for _, o := range opts {
+		o(ret)
+	}
+	ret.mux = http.NewServeMux()
+	ret.mux.HandleFunc("/trips", ret.listTrips)
+	ret.mux.HandleFunc("/trips/", ret.getTrip)
+	ret.mux.HandleFunc("/segments", ret.listSegments)
+	ret.mux.HandleFunc("/health", ret.health)
+	ret.mux.HandleFunc("/stream", ret.stream)
+	return ret
+}
+
+// Update replaces the served trips with those in results, adds its completed segments to those retained, records
+// the outcome of each feed pull and pushes the segments and trip events to any open streams
+func (s *Server) Update(results mta.StateUpdateResults) {
+	now := s.now()
+	s.publish(results)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.trips = make([]mta.TripUpdate, len(results.Trips))
+	copy(s.trips, results.Trips)
+
+	s.segments = append(s.segments, results.CompletedSegments...)
+	if overflow := len(s.segments) - s.maxSegments; overflow > 0 {
+		s.segments = append(make([]mta.Segment, 0, s.maxSegments), s.segments[overflow:]...)
+	}
+
+	for _, report := range results.Feeds {
+		health := s.feedHealth(report.Name)
+		health.LastAttempt = now
+		health.Stale = report.Stale
+		health.Healthy = report.Healthy()
+		health.Error = ""
+		if report.Err != nil {
+			health.Error = report.Err.Error()
+		} else {
+			success := now
+			health.LastSuccess = &success
+		}
+	}
+	success := now
+	s.store = &StoreHealth{LastSuccess: &success, LastAttempt: now, Healthy: true}
+	s.lastUpdate = &now
+}
+
+// UpdateFailed records a StateProcessor run that failed outright. A mta.StoreError is reported against the store,
+// leaving the feeds as they were. Anything else came from pulling the feeds, such as every feed failing, so no feed can
+// be vouched for and all are reported unhealthy with the error until a run succeeds.
+func (s *Server) UpdateFailed(err error) {
+	now := s.now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var storeErr *mta.StoreError
+	if errors.As(err, &storeErr) {
+		store := StoreHealth{LastAttempt: now, Error: err.Error()}
+		if s.store != nil {
+			store.LastSuccess = s.store.LastSuccess
+		}
+		s.store = &store
+		return
+	}
+	for i := range s.feeds {
+		s.feeds[i].LastAttempt = now
+		s.feeds[i].Healthy = false
+		s.feeds[i].Error = err.Error()
+	}
+}
+
+// feedHealth returns the health entry for the named feed, adding one if the feed hasn't been seen before. The mutex
+// must be held for writing.
+func (s *Server) feedHealth(name string) *FeedHealth {
+	for i := range s.feeds {
+		if s.feeds[i].Name == name {
+			return &s.feeds[i]
+		}
+	}
+	s.feeds = append(s.feeds, FeedHealth{Name: name})
+	return &s.feeds[len(s.feeds)-1]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(r, w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listTrips(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	route := query.Get("route")
+	direction := query.Get("direction")
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make([]mta.TripUpdate, 0)
+	for _, t := range s.trips {
+		if route != "" && t.RouteId != route {
+			continue
+		}
+		if direction != "" && (t.Direction == nil || !strings.EqualFold(string(*t.Direction), direction)) {
+			continue
+		}
+		ret = append(ret, t)
+	}
+	writeJSON(r, w, http.StatusOK, ret)
+}
+
+func (s *Server) getTrip(w http.ResponseWriter, r *http.Request) {
+	tripID := strings.TrimPrefix(r.URL.Path, "/trips/")
+	if tripID == "" {
+		s.listTrips(w, r)
+		return
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, t := range s.trips {
+		if t.TripId == tripID {
+			writeJSON(r, w, http.StatusOK, t)
+			return
+		}
+	}
+	writeError(r, w, http.StatusNotFound, fmt.Sprintf("trip %q not found", tripID))
+}
+
+func (s *Server) listSegments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	route := query.Get("route")
+	from := query.Get("from")
+	to := query.Get("to")
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(r, w, http.StatusBadRequest, fmt.Sprintf("since must be an RFC3339 timestamp, got %q", v))
+			return
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make([]mta.Segment, 0)
+	for _, seg := range s.segments {
+		if route != "" && seg.RouteID != route {
+			continue
+		}
+		if from != "" && !mta.SameStop(from, seg.FromStation) {
+			continue
+		}
+		if to != "" && !mta.SameStop(to, seg.ToStation) {
+			continue
+		}
+		if !since.IsZero() && seg.ArriveAt.Before(since) {
+			continue
+		}
+		ret = append(ret, seg)
+	}
+	writeJSON(r, w, http.StatusOK, ret)
+}
+
+// health responds with 200 once every feed's most recent pull was healthy, and 503 until then or if any feed is
+// currently failing or stale, or the store is failing
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := healthResponse{
+		Status:     "ok",
+		LastUpdate: s.lastUpdate,
+		Feeds:      s.feeds,
+		Store:      s.store,
+	}
+	status := http.StatusOK
+	if s.lastUpdate == nil {
+		res.Status = "starting"
+		status = http.StatusServiceUnavailable
+	} else {
+		for _, f := range s.feeds {
+			if !f.Healthy {
+				res.Status = "degraded"
+				status = http.StatusServiceUnavailable
+				break
+			}
+		}
+		if s.store != nil && !s.store.Healthy {
+			res.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(r, w, status, res)
+}
+
+func writeError(r *http.Request, w http.ResponseWriter, status int, message string) {
+	writeJSON(r, w, status, errorResponse{Error: message})
+}
+
+func writeJSON(r *http.Request, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Warn().Err(err).Msg("error writing response")
+	}
+}
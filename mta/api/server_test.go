@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResults() mta.StateUpdateResults {
+	north := mta.DirectionNorth
+	south := mta.DirectionSouth
+	return mta.StateUpdateResults{
+		Trips: []mta.TripUpdate{
+			{TripId: "084421_G..N", RouteId: "G", Direction: &north, Feed: "g"},
+			{TripId: "084500_G..S", RouteId: "G", Direction: &south, Feed: "g"},
+			{TripId: "085000_A..N", RouteId: "A", Direction: &north, Feed: "ace"},
+		},
+		CompletedSegments: []mta.Segment{
+			{
+				FromStation: "F27N",
+				ToStation:   "F26N",
+				DepartAt:    time.Date(2023, 7, 20, 18, 4, 11, 0, time.UTC),
+				ArriveAt:    time.Date(2023, 7, 20, 18, 6, 15, 0, time.UTC),
+				TripID:      "084421_G..N",
+				RouteID:     "G",
+			},
+			{
+				FromStation: "F26N",
+				ToStation:   "F25N",
+				DepartAt:    time.Date(2023, 7, 20, 18, 6, 15, 0, time.UTC),
+				ArriveAt:    time.Date(2023, 7, 20, 18, 8, 41, 0, time.UTC),
+				TripID:      "084421_G..N",
+				RouteID:     "G",
+			},
+		},
+		Feeds: []mta.FeedReport{
+			{Name: "g"},
+			{Name: "ace"},
+		},
+	}
+}
+
+func get(t *testing.T, s *Server, target string, dest any) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), dest))
+	return rec.Code
+}
+
+func tripIDs(trips []mta.TripUpdate) []string {
+	ret := make([]string, len(trips))
+	for i, t := range trips {
+		ret[i] = t.TripId
+	}
+	return ret
+}
+
+func TestServer_Trips(t *testing.T) {
+	s := NewServer()
+	s.Update(testResults())
+
+	testCases := []struct {
+		target   string
+		expected []string
+	}{
+		{target: "/trips", expected: []string{"084421_G..N", "084500_G..S", "085000_A..N"}},
+		{target: "/trips?route=G", expected: []string{"084421_G..N", "084500_G..S"}},
+		{target: "/trips?direction=north", expected: []string{"084421_G..N", "085000_A..N"}},
+		{target: "/trips?route=G&direction=SOUTH", expected: []string{"084500_G..S"}},
+		{target: "/trips?route=L", expected: []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target, func(t *testing.T) {
+			var got []mta.TripUpdate
+			assert.Equal(t, http.StatusOK, get(t, s, tc.target, &got))
+			assert.Equal(t, tc.expected, tripIDs(got))
+		})
+	}
+
+	var trip mta.TripUpdate
+	assert.Equal(t, http.StatusOK, get(t, s, "/trips/084500_G..S", &trip))
+	assert.Equal(t, testResults().Trips[1], trip)
+
+	var errRes errorResponse
+	assert.Equal(t, http.StatusNotFound, get(t, s, "/trips/nope", &errRes))
+	assert.Equal(t, `trip "nope" not found`, errRes.Error)
+}
+
+func TestServer_Segments(t *testing.T) {
+	s := NewServer(WithSegmentRetention(3))
+	s.Update(testResults())
+
+	testCases := []struct {
+		target   string
+		expected int
+	}{
+		{target: "/segments", expected: 2},
+		{target: "/segments?route=G", expected: 2},
+		{target: "/segments?route=A", expected: 0},
+		{target: "/segments?from=F26", expected: 1},
+		{target: "/segments?from=F27N&to=F26N", expected: 1},
+		{target: "/segments?since=2023-07-20T18:07:00Z", expected: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target, func(t *testing.T) {
+			var got []mta.Segment
+			assert.Equal(t, http.StatusOK, get(t, s, tc.target, &got))
+			assert.Len(t, got, tc.expected)
+		})
+	}
+
+	var errRes errorResponse
+	assert.Equal(t, http.StatusBadRequest, get(t, s, "/segments?since=yesterday", &errRes))
+
+	// only the most recent segments are retained
+	s.Update(testResults())
+	var got []mta.Segment
+	get(t, s, "/segments", &got)
+	assert.Equal(t, testResults().CompletedSegments[1:], got[:1])
+	assert.Len(t, got, 3)
+}
+
+func TestServer_Health(t *testing.T) {
+	s := NewServer()
+	first := time.Date(2023, 7, 20, 18, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second * 30)
+	s.now = func() time.Time {
+		return first
+	}
+
+	var res healthResponse
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/health", &res))
+	assert.Equal(t, "starting", res.Status)
+
+	s.Update(testResults())
+	assert.Equal(t, http.StatusOK, get(t, s, "/health", &res))
+	assert.Equal(t, "ok", res.Status)
+
+	s.now = func() time.Time {
+		return second
+	}
+	results := testResults()
+	results.Feeds[1].Err = errors.New("kaboom")
+	s.Update(results)
+	res = healthResponse{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/health", &res))
+	assert.Equal(t, "degraded", res.Status)
+	assert.Equal(t, []FeedHealth{
+		{Name: "g", LastSuccess: &second, LastAttempt: second, Healthy: true},
+		{Name: "ace", LastSuccess: &first, LastAttempt: second, Error: "kaboom"},
+	}, res.Feeds)
+}
+
+func TestServer_Health_AllFeedsFailing(t *testing.T) {
+	s := NewServer()
+	first := time.Date(2023, 7, 20, 18, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second * 30)
+	s.now = func() time.Time {
+		return first
+	}
+
+	// failing before anything has worked is still starting up
+	s.UpdateFailed(errors.New("everything is down"))
+	var res healthResponse
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/health", &res))
+	assert.Equal(t, "starting", res.Status)
+
+	s.Update(testResults())
+	res = healthResponse{}
+	assert.Equal(t, http.StatusOK, get(t, s, "/health", &res))
+
+	s.now = func() time.Time {
+		return second
+	}
+	s.UpdateFailed(errors.New("everything is down"))
+	res = healthResponse{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/health", &res))
+	assert.Equal(t, "degraded", res.Status)
+	assert.Equal(t, &first, res.LastUpdate)
+	assert.Equal(t, []FeedHealth{
+		{Name: "g", LastSuccess: &first, LastAttempt: second, Error: "everything is down"},
+		{Name: "ace", LastSuccess: &first, LastAttempt: second, Error: "everything is down"},
+	}, res.Feeds)
+
+	// and recovers with the next good run
+	s.Update(testResults())
+	res = healthResponse{}
+	assert.Equal(t, http.StatusOK, get(t, s, "/health", &res))
+	assert.Equal(t, "ok", res.Status)
+}
+
+func TestServer_Health_StoreFailing(t *testing.T) {
+	s := NewServer()
+	first := time.Date(2023, 7, 20, 18, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second * 30)
+	s.now = func() time.Time {
+		return first
+	}
+
+	s.Update(testResults())
+	var res healthResponse
+	assert.Equal(t, http.StatusOK, get(t, s, "/health", &res))
+	assert.Equal(t, &StoreHealth{LastSuccess: &first, LastAttempt: first, Healthy: true}, res.Store)
+
+	s.now = func() time.Time {
+		return second
+	}
+	s.UpdateFailed(&mta.StoreError{Op: "record", Err: errors.New("disk full")})
+	res = healthResponse{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/health", &res))
+	assert.Equal(t, "degraded", res.Status)
+	assert.Equal(t, &first, res.LastUpdate)
+	// the feeds were pulled fine, only the store is at fault
+	assert.Equal(t, []FeedHealth{
+		{Name: "g", LastSuccess: &first, LastAttempt: first, Healthy: true},
+		{Name: "ace", LastSuccess: &first, LastAttempt: first, Healthy: true},
+	}, res.Feeds)
+	assert.Equal(t, &StoreHealth{LastSuccess: &first, LastAttempt: second, Error: "failed to record state: disk full"}, res.Store)
+
+	s.Update(testResults())
+	res = healthResponse{}
+	assert.Equal(t, http.StatusOK, get(t, s, "/health", &res))
+	assert.Equal(t, "ok", res.Status)
+	assert.Equal(t, &StoreHealth{LastSuccess: &second, LastAttempt: second, Healthy: true}, res.Store)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/rs/zerolog"
+)
+
+const (
+	streamEventSegment = "segment"
+	streamEventTrip    = "trip"
+)
+
+type streamEvent struct {
+	name string
+	data any
+}
+
+type subscriber struct {
+	filter mta.SegmentFilter
+	events chan streamEvent
+}
+
+// matchesTrip applies the filter to trip events, a trip matches the station filter if any of the stops it is known to
+// serve do
+func (s *subscriber) matchesTrip(e mta.TripEvent, stops []string) bool {
+	if len(s.filter.Routes) > 0 {
+		found := false
+		for _, r := range s.filter.Routes {
+			if r == e.RouteID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.filter.Stations) == 0 {
+		return true
+	}
+	for _, st := range s.filter.Stations {
+		for _, stop := range stops {
+			if mta.SameStop(st, stop) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tripStops returns the stops each trip with an event in results is known to serve: those it had left to make before
+// the update, those it still has to make after it, and either end of the segments it completed. Trips that have been
+// dropped are looked up in the trips served before the update, so the mutex must not be held for writing.
+func (s *Server) tripStops(results mta.StateUpdateResults) map[string][]string {
+	ret := make(map[string][]string)
+	if len(results.TripEvents) == 0 {
+		return ret
+	}
+	add := func(tripID string, stops ...string) {
+		ret[tripID] = append(ret[tripID], stops...)
+	}
+	s.mutex.RLock()
+	for _, t := range s.trips {
+		for _, stu := range t.StopTimeUpdate {
+			add(t.TripId, stu.StopID)
+		}
+	}
+	s.mutex.RUnlock()
+	for _, t := range results.Trips {
+		for _, stu := range t.StopTimeUpdate {
+			add(t.TripId, stu.StopID)
+		}
+	}
+	for _, seg := range results.CompletedSegments {
+		add(seg.TripID, seg.FromStation, seg.ToStation)
+	}
+	return ret
+}
+
+// subscribe registers a new stream subscriber, returning nil if the server has been closed
+func (s *Server) subscribe(filter mta.SegmentFilter) *subscriber {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	sub := &subscriber{
+		filter: filter,
+		events: make(chan streamEvent, s.streamBuffer),
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	s.dropSubscriber(sub)
+}
+
+// dropSubscriber closes the subscribers channel, ending its stream. The subscriber mutex must be held.
+func (s *Server) dropSubscriber(sub *subscriber) {
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends the segments and trip events in results to every subscriber whose filter they match. Publishing never
+// blocks on a slow subscriber, a subscriber that has fallen a full buffer behind is disconnected and left to reconnect.
+func (s *Server) publish(results mta.StateUpdateResults) {
+	stops := s.tripStops(results)
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	for sub := range s.subscribers {
+		events := make([]streamEvent, 0)
+		for _, seg := range results.CompletedSegments {
+			if sub.filter.Matches(seg) {
+				events = append(events, streamEvent{name: streamEventSegment, data: seg})
+			}
+		}
+		for _, e := range results.TripEvents {
+			if sub.matchesTrip(e, stops[e.TripID]) {
+				events = append(events, streamEvent{name: streamEventTrip, data: e})
+			}
+		}
+		for _, e := range events {
+			select {
+			case sub.events <- e:
+			default:
+				s.dropSubscriber(sub)
+			}
+			if _, ok := s.subscribers[sub]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// Close ends every open stream and refuses new ones, it should be called before shutting down the HTTP server as
+// streams otherwise never finish
+func (s *Server) Close() {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		s.dropSubscriber(sub)
+	}
+}
+
+// stream pushes completed segments and trip lifecycle events as Server-Sent Events. The route and station query
+// parameters may be repeated or comma separated, and filter the events sent the same way a mta.SegmentFilter does.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(r, w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	query := r.URL.Query()
+	sub := s.subscribe(mta.SegmentFilter{
+		Routes:   splitParam(query["route"]),
+		Stations: splitParam(query["station"]),
+	})
+	if sub == nil {
+		writeError(r, w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	defer s.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	keepAlive := time.NewTicker(s.keepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e.data)
+			if err != nil {
+				zerolog.Ctx(r.Context()).Err(err).Msg("error encoding stream event")
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func splitParam(values []string) []string {
+	ret := make([]string, 0)
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret
+}
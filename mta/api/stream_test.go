@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subscriberCount(s *Server) int {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	return len(s.subscribers)
+}
+
+// readStream returns the event and data lines of the stream until it ends. It runs off the test goroutine so failures
+// are asserted rather than required.
+func readStream(t *testing.T, ctx context.Context, url string, ready chan<- struct{}) []string {
+	defer close(ready)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	ready <- struct{}{}
+
+	ret := make([]string, 0)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+func TestServer_Stream(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:  "everything",
+			query: "",
+			expected: []string{
+				"event: segment",
+				`data: {"fromStation":"F27N","toStation":"F26N","departAt":"2023-07-20T18:04:11Z","arriveAt":"2023-07-20T18:06:15Z","tripId":"084421_G..N","routeId":"G","trainId":"","isAssigned":false}`,
+				"event: segment",
+				`data: {"fromStation":"F26N","toStation":"F25N","departAt":"2023-07-20T18:06:15Z","arriveAt":"2023-07-20T18:08:41Z","tripId":"084421_G..N","routeId":"G","trainId":"","isAssigned":false}`,
+				"event: trip",
+				`data: {"type":"started","tripId":"085000_A..N","routeId":"A","at":"2023-07-20T18:09:00Z"}`,
+				"event: trip",
+				`data: {"type":"completed","tripId":"084421_G..N","routeId":"G","at":"2023-07-20T18:09:00Z"}`,
+			},
+		},
+		{
+			name:  "route",
+			query: "?route=A,L",
+			expected: []string{
+				"event: trip",
+				`data: {"type":"started","tripId":"085000_A..N","routeId":"A","at":"2023-07-20T18:09:00Z"}`,
+			},
+		},
+		{
+			name:  "station only",
+			query: "?station=F25",
+			expected: []string{
+				"event: segment",
+				`data: {"fromStation":"F26N","toStation":"F25N","departAt":"2023-07-20T18:06:15Z","arriveAt":"2023-07-20T18:08:41Z","tripId":"084421_G..N","routeId":"G","trainId":"","isAssigned":false}`,
+				"event: trip",
+				`data: {"type":"completed","tripId":"084421_G..N","routeId":"G","at":"2023-07-20T18:09:00Z"}`,
+			},
+		},
+		{
+			name:  "station served by a trip still running",
+			query: "?station=A02",
+			expected: []string{
+				"event: trip",
+				`data: {"type":"started","tripId":"085000_A..N","routeId":"A","at":"2023-07-20T18:09:00Z"}`,
+			},
+		},
+		{
+			name:  "station",
+			query: "?station=F25&route=G",
+			expected: []string{
+				"event: segment",
+				`data: {"fromStation":"F26N","toStation":"F25N","departAt":"2023-07-20T18:06:15Z","arriveAt":"2023-07-20T18:08:41Z","tripId":"084421_G..N","routeId":"G","trainId":"","isAssigned":false}`,
+				"event: trip",
+				`data: {"type":"completed","tripId":"084421_G..N","routeId":"G","at":"2023-07-20T18:09:00Z"}`,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			s := NewServer()
+			httpServer := httptest.NewServer(s)
+			defer httpServer.Close()
+
+			ready := make(chan struct{}, 1)
+			lines := make(chan []string)
+			go func() {
+				lines <- readStream(t, ctx, httpServer.URL+"/stream"+tc.query, ready)
+			}()
+			<-ready
+			require.Eventually(t, func() bool {
+				return subscriberCount(s) == 1
+			}, time.Second, time.Millisecond*10)
+
+			at := time.Date(2023, 7, 20, 18, 9, 0, 0, time.UTC)
+			results := testResults()
+			results.Trips[2].StopTimeUpdate = []mta.StopTimeUpdate{{StopID: "A03N"}, {StopID: "A02N"}}
+			results.TripEvents = []mta.TripEvent{
+				{Type: mta.TripStarted, TripID: "085000_A..N", RouteID: "A", At: at},
+				{Type: mta.TripCompleted, TripID: "084421_G..N", RouteID: "G", At: at},
+			}
+			s.Update(results)
+			// closing the server ends the stream
+			s.Close()
+
+			assert.Equal(t, tc.expected, <-lines)
+			assert.Equal(t, 0, subscriberCount(s))
+		})
+	}
+}
+
+func TestServer_Stream_SlowSubscriber(t *testing.T) {
+	s := NewServer(WithStreamBuffer(1))
+	sub := s.subscribe(mta.SegmentFilter{})
+	require.NotNil(t, sub)
+
+	s.Update(testResults())
+	// the second segment didn't fit so the subscriber was dropped
+	assert.Equal(t, 0, subscriberCount(s))
+	e, ok := <-sub.events
+	assert.True(t, ok)
+	assert.Equal(t, streamEventSegment, e.name)
+	_, ok = <-sub.events
+	assert.False(t, ok)
+
+	s.Close()
+	assert.Nil(t, s.subscribe(mta.SegmentFilter{}))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "shutting down"))
+}
@@ -0,0 +1,4 @@
+package enrich
+
+// Segment enrichment: decorates segments with station names, boroughs and structures, route colors and the distance
+// between stations, all taken from the static GTFS data and Stations.csv.
@@ -0,0 +1,90 @@
+package enrich
+
+import (
+	"math"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+)
+
+// earthRadiusMeters is the mean radius of the earth, close enough for distances between neighbouring stations
+const earthRadiusMeters = 6371008.8
+
+// Enricher implements mta.SegmentEnricher using static data
+type Enricher struct {
+	schedule *static.Schedule
+	stations *static.StationIndex
+}
+
+// NewEnricher uses the schedule for stop names and locations and route metadata, and stations (which may be nil) for
+// boroughs, structures and lines
+func NewEnricher(schedule *static.Schedule, stations *static.StationIndex) *Enricher {
+	return &Enricher{
+		schedule: schedule,
+		stations: stations,
+	}
+}
+
+// Enrich returns the segment with its details filled in from whatever static data is known about it. Unknown stops or
+// routes leave the corresponding details empty rather than failing, the feed is often ahead of the static data.
+func (e *Enricher) Enrich(segment mta.Segment) mta.Segment {
+	from, fromLoc := e.station(segment.FromStation)
+	to, toLoc := e.station(segment.ToStation)
+	details := &mta.SegmentDetails{
+		From: from,
+		To:   to,
+	}
+	if route, ok := e.schedule.Route(segment.RouteID); ok {
+		details.Route = mta.RouteDetails{
+			ShortName: route.ShortName,
+			Color:     route.Color,
+		}
+	}
+	if fromLoc != nil && toLoc != nil {
+		details.DistanceMeters = Distance(*fromLoc, *toLoc)
+	}
+	segment.Details = details
+	return segment
+}
+
+// station gathers what is known about the stop, along with its location if known
+func (e *Enricher) station(stopID string) (mta.StationDetails, *Location) {
+	ret := mta.StationDetails{}
+	var loc *Location
+	if stop, ok := e.schedule.ParentStop(stopID); ok {
+		ret.Name = stop.Name
+		loc = &Location{Latitude: stop.Latitude, Longitude: stop.Longitude}
+	}
+	if e.stations == nil {
+		return ret, loc
+	}
+	if st, ok := e.stations.Station(stopID); ok {
+		ret.Name = st.Name
+		ret.Borough = st.Borough
+		ret.Structure = st.Structure
+		ret.Line = st.Line
+		if loc == nil {
+			loc = &Location{Latitude: st.Latitude, Longitude: st.Longitude}
+		}
+	}
+	return ret, loc
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Distance is the great circle distance between the locations in meters
+func Distance(a, b Location) float64 {
+	lat1 := radians(a.Latitude)
+	lat2 := radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
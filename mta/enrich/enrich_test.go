@@ -0,0 +1,86 @@
+package enrich
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtures = "../static/fixtures/"
+
+func TestEnricher_Enrich(t *testing.T) {
+	schedule, err := static.Load(fixtures)
+	require.NoError(t, err)
+	stations, err := static.LoadStations(fixtures + "Stations.csv")
+	require.NoError(t, err)
+
+	departAt := time.Date(2023, 7, 23, 0, 6, 0, 0, time.UTC)
+	segment := mta.Segment{
+		FromStation: "101S",
+		ToStation:   "103S",
+		DepartAt:    departAt,
+		ArriveAt:    departAt.Add(90 * time.Second),
+		TripID:      "000600_1..S03R",
+		RouteID:     "1",
+	}
+
+	t.Run("with stations", func(t *testing.T) {
+		res := NewEnricher(schedule, static.NewStationIndex(schedule, stations)).Enrich(segment)
+		require.NotNil(t, res.Details)
+		assert.Equal(t, mta.StationDetails{
+			Name:      "Van Cortlandt Park-242 St",
+			Borough:   "Bx",
+			Structure: "Elevated",
+			Line:      "Broadway - 7Av",
+		}, res.Details.From)
+		// 103 is in stops.txt but not Stations.csv, so only its name is known
+		assert.Equal(t, mta.StationDetails{Name: "238 St"}, res.Details.To)
+		assert.Equal(t, mta.RouteDetails{ShortName: "1", Color: "EE352E"}, res.Details.Route)
+		assert.InDelta(t, 544.5, res.Details.DistanceMeters, 0.5)
+
+		speed, ok := res.AverageSpeed()
+		require.True(t, ok)
+		assert.InDelta(t, 6.05, speed, 0.01)
+
+		// the segment itself is left alone
+		res.Details = nil
+		assert.Equal(t, segment, res)
+	})
+
+	t.Run("schedule only", func(t *testing.T) {
+		res := NewEnricher(schedule, nil).Enrich(segment)
+		require.NotNil(t, res.Details)
+		assert.Equal(t, mta.StationDetails{Name: "Van Cortlandt Park-242 St"}, res.Details.From)
+		assert.InDelta(t, 544.5, res.Details.DistanceMeters, 0.5)
+	})
+
+	t.Run("unknown stop and route", func(t *testing.T) {
+		unknown := segment
+		unknown.ToStation = "F27S"
+		unknown.RouteID = "G"
+		res := NewEnricher(schedule, static.NewStationIndex(schedule, stations)).Enrich(unknown)
+		require.NotNil(t, res.Details)
+		assert.Equal(t, mta.StationDetails{}, res.Details.To)
+		assert.Equal(t, mta.RouteDetails{}, res.Details.Route)
+		assert.Zero(t, res.Details.DistanceMeters)
+		_, ok := res.AverageSpeed()
+		assert.False(t, ok)
+
+		b, err := json.Marshal(res.Details)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"from":{"name":"Van Cortlandt Park-242 St","borough":"Bx","structure":"Elevated","line":"Broadway - 7Av"},"to":{},"route":{}}`, string(b))
+	})
+}
+
+func TestDistance(t *testing.T) {
+	// Times Sq-42 St to Grand Central-42 St on the S shuttle, roughly 0.7km apart
+	timesSq := Location{Latitude: 40.755983, Longitude: -73.986229}
+	grandCentral := Location{Latitude: 40.752769, Longitude: -73.979189}
+	assert.InDelta(t, 692, Distance(timesSq, grandCentral), 5)
+	assert.Zero(t, Distance(timesSq, timesSq))
+}
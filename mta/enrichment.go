@@ -0,0 +1,45 @@
+package mta
+
+import "time"
+
+// SegmentEnricher decorates segments with static metadata about their stations and route, see the enrich package
+type SegmentEnricher interface {
+	Enrich(segment Segment) Segment
+}
+
+// SegmentDetails is static metadata about a segment, only present when segments have been enriched
+type SegmentDetails struct {
+	From  StationDetails `json:"from"`
+	To    StationDetails `json:"to"`
+	Route RouteDetails   `json:"route"`
+	// DistanceMeters is the straight line distance between the stations, zero if either location is unknown
+	DistanceMeters float64 `json:"distanceMeters,omitempty"`
+}
+
+type StationDetails struct {
+	Name    string `json:"name,omitempty"`
+	Borough string `json:"borough,omitempty"`
+	// Structure is how the station is built, eg Subway, Elevated, Open Cut
+	Structure string `json:"structure,omitempty"`
+	Line      string `json:"line,omitempty"`
+}
+
+type RouteDetails struct {
+	ShortName string `json:"shortName,omitempty"`
+	// Color is the route's hex color without a leading #, eg 2850AD
+	Color string `json:"color,omitempty"`
+}
+
+// Duration is how long the train took to run the segment
+func (s Segment) Duration() time.Duration {
+	return s.ArriveAt.Sub(s.DepartAt)
+}
+
+// AverageSpeed is the segment's average speed in meters per second, false if the segment hasn't been enriched with a
+// distance or took no time at all
+func (s Segment) AverageSpeed() (float64, bool) {
+	if s.Details == nil || s.Details.DistanceMeters == 0 || s.Duration() <= 0 {
+		return 0, false
+	}
+	return s.Details.DistanceMeters / s.Duration().Seconds(), true
+}
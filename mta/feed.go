@@ -2,11 +2,16 @@ package mta
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/jonsabados/mta2furious/mta/wire"
+	"github.com/rs/zerolog"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -73,6 +78,12 @@ type StopTimeUpdate struct {
 	// schedule.  The rules engine for the 'countdown' clocks will remove this
 	// train from all schedule stations.
 	ActualTrack *string `json:"actualTrack,omitempty"`
+	// ArrivalObserved is true when Arrival was taken from the vehicle being reported as STOPPED_AT this stop rather
+	// than from a predicted arrival time
+	ArrivalObserved bool `json:"arrivalObserved,omitempty"`
+	// Inferred is true when the stop was assumed to be complete rather than seen to drop off the feed, either because
+	// the whole trip disappeared or because the train was reported stopped further along
+	Inferred bool `json:"inferred,omitempty"`
 	// IsComplete represents if this TripUpdate has completed (in practice this becomes True when an assigned record drops off the feed)
 	IsComplete bool `json:"isComplete"`
 }
@@ -106,97 +117,458 @@ type TripUpdate struct {
 	Direction *Direction `json:"direction,omitempty"`
 
 	StopTimeUpdate []StopTimeUpdate `json:"stopTimeUpdate,omitempty"`
+	// Feed is the name of the feed the trip was observed in
+	Feed string `json:"feed,omitempty"`
+	// Vehicle is the most recent position reported for the train running this trip, if any
+	Vehicle *VehiclePosition `json:"vehicle,omitempty"`
+	// VanishedAt is set while a trip with completed stops is being held on to after dropping off the feed, and is when
+	// it was first noticed missing
+	VanishedAt *time.Time `json:"vanishedAt,omitempty"`
+	// History holds the stops the trip has already completed, oldest first, so the whole trip can be summarized once it
+	// finishes
+	History []StopTimeUpdate `json:"history,omitempty"`
+}
+
+type VehicleStopStatus string
+
+const (
+	// VehicleIncomingAt means the vehicle is just about to arrive at the stop
+	VehicleIncomingAt VehicleStopStatus = "INCOMING_AT"
+	// VehicleStoppedAt means the vehicle is standing at the stop
+	VehicleStoppedAt VehicleStopStatus = "STOPPED_AT"
+	// VehicleInTransitTo means the vehicle has departed the previous stop and is in transit
+	VehicleInTransitTo VehicleStopStatus = "IN_TRANSIT_TO"
+)
+
+type VehiclePosition struct {
+	TripId  string `json:"tripId,omitempty"`
+	RouteId string `json:"routeId,omitempty"`
+	// The stop sequence index of the current stop. NYCT does not populate this consistently, StopID should be
+	// preferred.
+	CurrentStopSequence *uint32 `json:"currentStopSequence,omitempty"`
+	// The stop the vehicle is at or heading to, the meaning depends on CurrentStatus
+	StopID        string            `json:"stopID,omitempty"`
+	CurrentStatus VehicleStopStatus `json:"currentStatus,omitempty"`
+	// Moment at which the vehicle's position was measured
+	Timestamp *time.Time `json:"timestamp,omitempty"`
 }
 
 type TripStatus struct {
-	Header      FeedHeader
-	TripUpdates []TripUpdate `json:"tripUpdates"`
+	Header           FeedHeader
+	TripUpdates      []TripUpdate      `json:"tripUpdates"`
+	VehiclePositions []VehiclePosition `json:"vehiclePositions"`
+	Alerts           []Alert           `json:"alerts"`
+	// Stale is true when the feed has not advanced since the prior pull, either because the server reported it was not
+	// modified or because the header timestamp did not move forward. Consumers should not infer anything from trips
+	// being absent from a stale status.
+	Stale bool `json:"stale"`
+	// DecodeErrors lists problems encountered converting the feed, entities with problems may have been skipped
+	DecodeErrors []DecodeError `json:"decodeErrors,omitempty"`
+}
+
+// DecodeError describes a problem converting part of a feed message. Problems are scoped to a single entity where
+// possible so the rest of the feed remains usable.
+type DecodeError struct {
+	// EntityID is the id of the feed entity the problem was found in, empty for problems with the feed header
+	EntityID string `json:"entityId,omitempty"`
+	// Field is the path of the offending field in the GTFS-realtime message
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Skipped is true if the entity could not be converted at all and was left out of the results
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func (e DecodeError) Error() string {
+	if e.EntityID == "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("entity %s: %s: %s", e.EntityID, e.Field, e.Message)
+}
+
+// StatusError is returned when a feed responds with a non 200 status code
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+	// Body holds the start of the response body, enough to see what the server had to say
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s: %s", e.StatusCode, e.Endpoint, e.Body)
+}
+
+// Retryable reports if the status indicates a transient problem worth retrying
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
 }
 
+const maxErrorBodySnippet = 512
+
 type LiveFeed struct {
-	endpoint string
-	apiKey   string
+	endpoint       string
+	apiKey         string
+	client         *http.Client
+	timeout        time.Duration
+	maxRetries     int
+	initialBackoff time.Duration
+	sleep          func(ctx context.Context, d time.Duration) error
+	name           string
+
+	// validators and results of the last successful pull, used for conditional requests and stale detection. The mutex
+	// is only held while they are read or updated, never across a request.
+	mutex         sync.Mutex
+	etag          string
+	lastModified  string
+	lastStatus    *TripStatus
+	lastTimestamp *time.Time
+}
+
+type LiveFeedOption func(f *LiveFeed)
+
+// WithHTTPClient overrides the http client used to pull the feed, http.DefaultClient is used otherwise
+func WithHTTPClient(client *http.Client) LiveFeedOption {
+	return func(f *LiveFeed) {
+		f.client = client
+	}
+}
+
+// WithTimeout sets the time limit for each attempt to pull the feed, defaults to 10 seconds
+func WithTimeout(timeout time.Duration) LiveFeedOption {
+	return func(f *LiveFeed) {
+		f.timeout = timeout
+	}
+}
+
+// WithRetries sets how many times a failed pull is retried, and the delay before the first retry. The delay doubles
+// with each subsequent retry. Only network errors, timeouts, 5xx and 429 responses are retried. Defaults to 2 retries
+// starting at 500ms.
+func WithRetries(maxRetries int, initialBackoff time.Duration) LiveFeedOption {
+	return func(f *LiveFeed) {
+		f.maxRetries = maxRetries
+		f.initialBackoff = initialBackoff
+	}
 }
 
-func NewLiveFeed(endpoint string, apikey string) *LiveFeed {
-	return &LiveFeed{
-		endpoint: endpoint,
-		apiKey:   apikey,
+// WithName sets the name the feed is identified by, defaults to the endpoint
+func WithName(name string) LiveFeedOption {
+	return func(f *LiveFeed) {
+		f.name = name
 	}
 }
 
+func NewLiveFeed(endpoint string, apikey string, opts ...LiveFeedOption) *LiveFeed {
+	ret := &LiveFeed{
+		name:           endpoint,
+		endpoint:       endpoint,
+		apiKey:         apikey,
+		client:         http.DefaultClient,
+		timeout:        time.Second * 10,
+		maxRetries:     2,
+		initialBackoff: time.Millisecond * 500,
+		sleep:          sleepCtx,
+	}
+	for _, o := range opts {
+		o(ret)
+	}
+	return ret
+}
+
+func (f *LiveFeed) Name() string {
+	return f.name
+}
+
+// Feed pulls the current state of the feed. Requests are conditional on the feed having changed since the last pull,
+// if it has not (or the feed header timestamp has not advanced) the returned status is flagged as Stale.
 func (f *LiveFeed) Feed(ctx context.Context) (TripStatus, error) {
-	// A, C, E lines
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.endpoint, nil)
+	f.mutex.Lock()
+	var cond validators
+	if f.lastStatus != nil {
+		cond = validators{etag: f.etag, lastModified: f.lastModified}
+	}
+	f.mutex.Unlock()
+
+	res, err := f.fetch(ctx, cond)
 	if err != nil {
 		return TripStatus{}, err
 	}
+	var status TripStatus
+	if !res.notModified {
+		var raw wire.FeedMessage
+		err = proto.Unmarshal(res.body, &raw)
+		if err != nil {
+			return TripStatus{}, err
+		}
+		status = convertFeedMessage(&raw)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if res.notModified {
+		if f.lastStatus == nil {
+			return TripStatus{}, fmt.Errorf("%s responded not modified but there is no prior pull", f.endpoint)
+		}
+		zerolog.Ctx(ctx).Debug().Str("feed", f.name).Msg("feed not modified since last pull")
+		ret := *f.lastStatus
+		ret.Stale = true
+		return ret, nil
+	}
+	if ts := status.Header.Timestamp; ts != nil && f.lastTimestamp != nil && !ts.After(*f.lastTimestamp) {
+		zerolog.Ctx(ctx).Info().Str("feed", f.name).Time("timestamp", *ts).Time("priorTimestamp", *f.lastTimestamp).Msg("feed timestamp has not advanced")
+		status.Stale = true
+		return status, nil
+	}
+	f.etag = res.etag
+	f.lastModified = res.lastModified
+	f.lastTimestamp = status.Header.Timestamp
+	f.lastStatus = &status
+	return status, nil
+}
+
+// validators make a request conditional on the feed having changed since it was last pulled
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+type fetchResult struct {
+	body         []byte
+	etag         string
+	lastModified string
+	notModified  bool
+}
+
+// fetch pulls the raw feed, retrying transient failures with exponential backoff
+func (f *LiveFeed) fetch(ctx context.Context, cond validators) (fetchResult, error) {
+	backoff := f.initialBackoff
+	for attempt := 0; ; attempt++ {
+		res, retryAfter, err := f.attempt(ctx, cond)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil || attempt >= f.maxRetries || !isRetryable(err) {
+			return fetchResult{}, err
+		}
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Str("endpoint", f.endpoint).Int("attempt", attempt+1).Dur("wait", wait).Msg("feed pull failed, retrying")
+		err = f.sleep(ctx, wait)
+		if err != nil {
+			return fetchResult{}, err
+		}
+		backoff *= 2
+	}
+}
+
+func (f *LiveFeed) attempt(ctx context.Context, cond validators) (fetchResult, time.Duration, error) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.endpoint, nil)
+	if err != nil {
+		return fetchResult{}, 0, err
+	}
 	req.Header.Add("x-api-key", f.apiKey)
-	res, err := http.DefaultClient.Do(req)
+	if cond.etag != "" {
+		req.Header.Add("If-None-Match", cond.etag)
+	}
+	if cond.lastModified != "" {
+		req.Header.Add("If-Modified-Since", cond.lastModified)
+	}
+	res, err := f.client.Do(req)
 	if err != nil {
-		return TripStatus{}, err
+		return fetchResult{}, 0, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return fetchResult{notModified: true}, 0, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySnippet))
+		return fetchResult{}, parseRetryAfter(res.Header.Get("Retry-After")), &StatusError{
+			Endpoint:   f.endpoint,
+			StatusCode: res.StatusCode,
+			Body:       string(snippet),
+		}
+	}
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return TripStatus{}, err
+		return fetchResult{}, 0, err
 	}
-	var raw wire.FeedMessage
-	err = proto.Unmarshal(body, &raw)
-	if err != nil {
-		return TripStatus{}, err
+	return fetchResult{
+		body:         body,
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+	}, 0, nil
+}
+
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
 	}
+	// anything else came from the transport (connection refused, reset, per attempt timeout, etc)
+	return true
+}
+
+// parseRetryAfter handles the delay-seconds form of Retry-After, returning 0 if absent or unparseable
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// convertFeedMessage maps the wire format to our domain model. Problems with individual entities are recorded in
+// DecodeErrors rather than failing the conversion, so one bad entity doesn't take the rest of the feed down with it.
+func convertFeedMessage(raw *wire.FeedMessage) TripStatus {
+	errs := make([]DecodeError, 0)
+	header, headerErrs := convertHeader(raw.GetHeader())
+	errs = append(errs, headerErrs...)
+	tripUpdates, tripErrs := convertEntities(raw.GetEntity())
+	errs = append(errs, tripErrs...)
+	vehicles := convertVehicles(raw.GetEntity())
+	attachVehicles(tripUpdates, vehicles)
 	return TripStatus{
-		Header:      convertHeader(raw.Header),
-		TripUpdates: convertEntities(raw.Entity),
-	}, nil
-}
-
-func convertHeader(header *wire.FeedHeader) FeedHeader {
-	extension := proto.GetExtension(header, wire.E_NyctFeedHeader).(*wire.NyctFeedHeader)
-	replacementPeriod := make([]TripReplacementPeriod, len(extension.TripReplacementPeriod))
-	for i, p := range extension.TripReplacementPeriod {
-		replacementPeriod[i] = TripReplacementPeriod{
-			RouteId: *p.RouteId,
-			ReplacementPeriod: TimeRange{
-				Start: convertTime(p.ReplacementPeriod.Start),
-				End:   convertTime(p.ReplacementPeriod.End),
-			},
+		Header:           header,
+		TripUpdates:      tripUpdates,
+		VehiclePositions: vehicles,
+		Alerts:           convertAlerts(raw.GetEntity()),
+		DecodeErrors:     errs,
+	}
+}
+
+func convertHeader(header *wire.FeedHeader) (FeedHeader, []DecodeError) {
+	errs := make([]DecodeError, 0)
+	if header == nil {
+		return FeedHeader{}, append(errs, DecodeError{Field: "header", Message: "missing feed header"})
+	}
+	if header.GtfsRealtimeVersion == nil {
+		errs = append(errs, DecodeError{Field: "header.gtfs_realtime_version", Message: "missing required field"})
+	}
+	// the service alerts feed does not carry the NYCT header extension
+	extension, _ := proto.GetExtension(header, wire.E_NyctFeedHeader).(*wire.NyctFeedHeader)
+	replacementPeriod := make([]TripReplacementPeriod, 0, len(extension.GetTripReplacementPeriod()))
+	for _, p := range extension.GetTripReplacementPeriod() {
+		if p.RouteId == nil {
+			errs = append(errs, DecodeError{Field: "header.trip_replacement_period.route_id", Message: "missing route id, period skipped"})
+			continue
+		}
+		period := TimeRange{}
+		if raw := p.GetReplacementPeriod(); raw != nil {
+			period.Start = convertTime(raw.Start)
+			period.End = convertTime(raw.End)
+		} else {
+			errs = append(errs, DecodeError{Field: "header.trip_replacement_period.replacement_period", Message: "missing replacement period"})
 		}
+		replacementPeriod = append(replacementPeriod, TripReplacementPeriod{
+			RouteId:           p.GetRouteId(),
+			ReplacementPeriod: period,
+		})
 	}
 	return FeedHeader{
-		GtfsRealtimeVersion:   *header.GtfsRealtimeVersion,
+		GtfsRealtimeVersion:   header.GetGtfsRealtimeVersion(),
 		Timestamp:             convertTime(header.Timestamp),
-		NyctSubwayVersion:     *extension.NyctSubwayVersion,
+		NyctSubwayVersion:     extension.GetNyctSubwayVersion(),
 		TripReplacementPeriod: replacementPeriod,
-	}
+	}, errs
 }
 
-func convertEntities(raw []*wire.FeedEntity) []TripUpdate {
+func convertEntities(raw []*wire.FeedEntity) ([]TripUpdate, []DecodeError) {
 	ret := make([]TripUpdate, 0, len(raw))
+	errs := make([]DecodeError, 0)
 	for _, r := range raw {
-		// we get records for vehicle and trip updates, but we really only care about trip updates
-		if r.TripUpdate == nil {
+		// vehicle positions and alerts are handled by convertVehicles and convertAlerts
+		if r.GetTripUpdate() == nil {
 			continue
 		}
-		ret = append(ret, convertEntity(r.TripUpdate))
+		tu, entityErrs, ok := convertEntity(r.GetId(), r.TripUpdate)
+		errs = append(errs, entityErrs...)
+		if ok {
+			ret = append(ret, tu)
+		}
 	}
-	return ret
+	return ret, errs
 }
 
-func convertEntity(raw *wire.TripUpdate) TripUpdate {
-	nytTrip := proto.GetExtension(raw.Trip, wire.E_NyctTripDescriptor).(*wire.NyctTripDescriptor)
-	isAssigned := false
-	if nytTrip.IsAssigned != nil {
-		isAssigned = *nytTrip.IsAssigned
+// convertEntity converts a single trip update. If the trip can't be identified ok will be false and the entity should
+// be skipped, otherwise any problems encountered are returned alongside the (possibly partial) trip update.
+func convertEntity(entityID string, raw *wire.TripUpdate) (tu TripUpdate, errs []DecodeError, ok bool) {
+	if raw.GetTrip().GetTripId() == "" {
+		return TripUpdate{}, []DecodeError{{EntityID: entityID, Field: "trip_update.trip.trip_id", Message: "missing trip id, entity skipped", Skipped: true}}, false
+	}
+	errs = make([]DecodeError, 0)
+	nytTrip, _ := proto.GetExtension(raw.Trip, wire.E_NyctTripDescriptor).(*wire.NyctTripDescriptor)
+	if nytTrip == nil {
+		errs = append(errs, DecodeError{EntityID: entityID, Field: "trip_update.trip.nyct_trip_descriptor", Message: "missing NYCT trip descriptor"})
+	}
+	if raw.Trip.RouteId == nil {
+		errs = append(errs, DecodeError{EntityID: entityID, Field: "trip_update.trip.route_id", Message: "missing route id"})
+	}
+	var direction *Direction
+	if nytTrip != nil {
+		direction = convertDirection(nytTrip.Direction)
 	}
+	stopTimeUpdates, stopErrs := convertStopTimeUpdates(entityID, raw.StopTimeUpdate)
+	errs = append(errs, stopErrs...)
 	return TripUpdate{
-		TripId:         *raw.Trip.TripId,
-		RouteId:        *raw.Trip.RouteId,
-		TrainId:        *nytTrip.TrainId,
-		IsAssigned:     isAssigned,
-		Direction:      convertDirection(nytTrip.Direction),
-		StopTimeUpdate: convertStopTimeUpdates(raw.StopTimeUpdate),
+		TripId:         raw.Trip.GetTripId(),
+		RouteId:        raw.Trip.GetRouteId(),
+		TrainId:        nytTrip.GetTrainId(),
+		IsAssigned:     nytTrip.GetIsAssigned(),
+		Direction:      direction,
+		StopTimeUpdate: stopTimeUpdates,
+	}, errs, true
+}
+
+func convertVehicles(raw []*wire.FeedEntity) []VehiclePosition {
+	ret := make([]VehiclePosition, 0)
+	for _, r := range raw {
+		if r.Vehicle == nil {
+			continue
+		}
+		ret = append(ret, convertVehicle(r.Vehicle))
+	}
+	return ret
+}
+
+func convertVehicle(raw *wire.VehiclePosition) VehiclePosition {
+	return VehiclePosition{
+		TripId:              raw.GetTrip().GetTripId(),
+		RouteId:             raw.GetTrip().GetRouteId(),
+		CurrentStopSequence: raw.CurrentStopSequence,
+		StopID:              raw.GetStopId(),
+		CurrentStatus:       VehicleStopStatus(raw.GetCurrentStatus().String()),
+		Timestamp:           convertTime(raw.Timestamp),
+	}
+}
+
+// attachVehicles links each trip update to the vehicle position reported for the same trip
+func attachVehicles(tripUpdates []TripUpdate, vehicles []VehiclePosition) {
+	byTrip := make(map[string]*VehiclePosition, len(vehicles))
+	for i := range vehicles {
+		byTrip[vehicles[i].TripId] = &vehicles[i]
+	}
+	for i := range tripUpdates {
+		if v, ok := byTrip[tripUpdates[i].TripId]; ok {
+			vehicle := *v
+			tripUpdates[i].Vehicle = &vehicle
+		}
 	}
 }
 
@@ -208,19 +580,29 @@ func convertDirection(raw *wire.NyctTripDescriptor_Direction) *Direction {
 	return &ret
 }
 
-func convertStopTimeUpdates(raw []*wire.TripUpdate_StopTimeUpdate) []StopTimeUpdate {
-	ret := make([]StopTimeUpdate, len(raw))
-	for i, r := range raw {
-		nytUpdate := proto.GetExtension(r, wire.E_NyctStopTimeUpdate).(*wire.NyctStopTimeUpdate)
-		ret[i] = StopTimeUpdate{
-			StopID:         *r.StopId,
+func convertStopTimeUpdates(entityID string, raw []*wire.TripUpdate_StopTimeUpdate) ([]StopTimeUpdate, []DecodeError) {
+	ret := make([]StopTimeUpdate, 0, len(raw))
+	errs := make([]DecodeError, 0)
+	for _, r := range raw {
+		if r.GetStopId() == "" {
+			errs = append(errs, DecodeError{EntityID: entityID, Field: "trip_update.stop_time_update.stop_id", Message: "missing stop id, stop skipped"})
+			continue
+		}
+		var scheduledTrack, actualTrack *string
+		nytUpdate, _ := proto.GetExtension(r, wire.E_NyctStopTimeUpdate).(*wire.NyctStopTimeUpdate)
+		if nytUpdate != nil {
+			scheduledTrack = nytUpdate.ScheduledTrack
+			actualTrack = nytUpdate.ActualTrack
+		}
+		ret = append(ret, StopTimeUpdate{
+			StopID:         r.GetStopId(),
 			Arrival:        extractTime(r.Arrival),
 			Departure:      extractTime(r.Departure),
-			ScheduledTrack: nytUpdate.ScheduledTrack,
-			ActualTrack:    nytUpdate.ActualTrack,
-		}
+			ScheduledTrack: scheduledTrack,
+			ActualTrack:    actualTrack,
+		})
 	}
-	return ret
+	return ret, errs
 }
 
 func convertTime(t *uint64) *time.Time {
@@ -232,9 +614,9 @@ func convertTime(t *uint64) *time.Time {
 }
 
 func extractTime(raw *wire.TripUpdate_StopTimeEvent) *time.Time {
-	if raw == nil {
+	if raw.GetTime() == 0 {
 		return nil
 	}
-	ret := time.Unix(*raw.Time, 0)
+	ret := time.Unix(raw.GetTime(), 0)
 	return &ret
 }
@@ -0,0 +1,534 @@
+package mta
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConvertFeedMessage(t *testing.T) {
+	strPtr := func(s string) *string {
+		return &s
+	}
+	timePtr := func(unix int64) *time.Time {
+		ret := time.Unix(unix, 0)
+		return &ret
+	}
+	north := DirectionNorth
+
+	nyctHeader := func() *wire.FeedHeader {
+		h := &wire.FeedHeader{
+			GtfsRealtimeVersion: proto.String("1.0"),
+			Timestamp:           proto.Uint64(1689876248),
+		}
+		proto.SetExtension(h, wire.E_NyctFeedHeader, &wire.NyctFeedHeader{
+			NyctSubwayVersion: proto.String("1.0"),
+			TripReplacementPeriod: []*wire.TripReplacementPeriod{
+				{RouteId: proto.String("G"), ReplacementPeriod: &wire.TimeRange{End: proto.Uint64(1689878048)}},
+			},
+		})
+		return h
+	}
+	nyctTrip := func(tripID string, trainID *string) *wire.TripDescriptor {
+		d := &wire.TripDescriptor{
+			TripId:  proto.String(tripID),
+			RouteId: proto.String("G"),
+		}
+		proto.SetExtension(d, wire.E_NyctTripDescriptor, &wire.NyctTripDescriptor{
+			TrainId:    trainID,
+			IsAssigned: proto.Bool(true),
+			Direction:  wire.NyctTripDescriptor_NORTH.Enum(),
+		})
+		return d
+	}
+	stop := func(stopID *string, at int64, track *string) *wire.TripUpdate_StopTimeUpdate {
+		s := &wire.TripUpdate_StopTimeUpdate{
+			StopId:    stopID,
+			Arrival:   &wire.TripUpdate_StopTimeEvent{Time: proto.Int64(at)},
+			Departure: &wire.TripUpdate_StopTimeEvent{Time: proto.Int64(at)},
+		}
+		if track != nil {
+			proto.SetExtension(s, wire.E_NyctStopTimeUpdate, &wire.NyctStopTimeUpdate{
+				ScheduledTrack: track,
+				ActualTrack:    track,
+			})
+		}
+		return s
+	}
+	expectedHeader := FeedHeader{
+		GtfsRealtimeVersion: "1.0",
+		Timestamp:           timePtr(1689876248),
+		NyctSubwayVersion:   "1.0",
+		TripReplacementPeriod: []TripReplacementPeriod{
+			{RouteId: "G", ReplacementPeriod: TimeRange{End: timePtr(1689878048)}},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		raw      *wire.FeedMessage
+		expected TripStatus
+	}{
+		{
+			name: "happy path",
+			raw: &wire.FeedMessage{
+				Header: nyctHeader(),
+				Entity: []*wire.FeedEntity{
+					{
+						Id: proto.String("000001G"),
+						TripUpdate: &wire.TripUpdate{
+							Trip: nyctTrip("084421_G..N", proto.String("1G 1404 CHU/CRS")),
+							StopTimeUpdate: []*wire.TripUpdate_StopTimeUpdate{
+								stop(proto.String("F27N"), 1689876251, proto.String("B2")),
+							},
+						},
+					},
+					{
+						Id: proto.String("000002G"),
+						Vehicle: &wire.VehiclePosition{
+							Trip:          nyctTrip("084421_G..N", proto.String("1G 1404 CHU/CRS")),
+							StopId:        proto.String("F27N"),
+							CurrentStatus: wire.VehiclePosition_STOPPED_AT.Enum(),
+							Timestamp:     proto.Uint64(1689876245),
+						},
+					},
+				},
+			},
+			expected: TripStatus{
+				Header: expectedHeader,
+				TripUpdates: []TripUpdate{
+					{
+						TripId:     "084421_G..N",
+						RouteId:    "G",
+						TrainId:    "1G 1404 CHU/CRS",
+						IsAssigned: true,
+						Direction:  &north,
+						StopTimeUpdate: []StopTimeUpdate{
+							{
+								StopID:         "F27N",
+								Arrival:        timePtr(1689876251),
+								Departure:      timePtr(1689876251),
+								ScheduledTrack: strPtr("B2"),
+								ActualTrack:    strPtr("B2"),
+							},
+						},
+						Vehicle: &VehiclePosition{
+							TripId:        "084421_G..N",
+							RouteId:       "G",
+							StopID:        "F27N",
+							CurrentStatus: VehicleStoppedAt,
+							Timestamp:     timePtr(1689876245),
+						},
+					},
+				},
+				VehiclePositions: []VehiclePosition{
+					{
+						TripId:        "084421_G..N",
+						RouteId:       "G",
+						StopID:        "F27N",
+						CurrentStatus: VehicleStoppedAt,
+						Timestamp:     timePtr(1689876245),
+					},
+				},
+				Alerts:       []Alert{},
+				DecodeErrors: []DecodeError{},
+			},
+		},
+		{
+			name: "missing header",
+			raw:  &wire.FeedMessage{},
+			expected: TripStatus{
+				TripUpdates:      []TripUpdate{},
+				VehiclePositions: []VehiclePosition{},
+				Alerts:           []Alert{},
+				DecodeErrors: []DecodeError{
+					{Field: "header", Message: "missing feed header"},
+				},
+			},
+		},
+		{
+			name: "header without NYCT extension",
+			raw: &wire.FeedMessage{
+				Header: &wire.FeedHeader{Timestamp: proto.Uint64(1689876248)},
+			},
+			expected: TripStatus{
+				Header: FeedHeader{
+					Timestamp:             timePtr(1689876248),
+					TripReplacementPeriod: []TripReplacementPeriod{},
+				},
+				TripUpdates:      []TripUpdate{},
+				VehiclePositions: []VehiclePosition{},
+				Alerts:           []Alert{},
+				DecodeErrors: []DecodeError{
+					{Field: "header.gtfs_realtime_version", Message: "missing required field"},
+				},
+			},
+		},
+		{
+			name: "replacement period without a time range",
+			raw: &wire.FeedMessage{
+				Header: func() *wire.FeedHeader {
+					h := &wire.FeedHeader{GtfsRealtimeVersion: proto.String("1.0")}
+					proto.SetExtension(h, wire.E_NyctFeedHeader, &wire.NyctFeedHeader{
+						TripReplacementPeriod: []*wire.TripReplacementPeriod{{RouteId: proto.String("A")}},
+					})
+					return h
+				}(),
+			},
+			expected: TripStatus{
+				Header: FeedHeader{
+					GtfsRealtimeVersion:   "1.0",
+					TripReplacementPeriod: []TripReplacementPeriod{{RouteId: "A"}},
+				},
+				TripUpdates:      []TripUpdate{},
+				VehiclePositions: []VehiclePosition{},
+				Alerts:           []Alert{},
+				DecodeErrors: []DecodeError{
+					{Field: "header.trip_replacement_period.replacement_period", Message: "missing replacement period"},
+				},
+			},
+		},
+		{
+			name: "trip without id is skipped, the rest survive",
+			raw: &wire.FeedMessage{
+				Header: nyctHeader(),
+				Entity: []*wire.FeedEntity{
+					{
+						Id:         proto.String("bad"),
+						TripUpdate: &wire.TripUpdate{Trip: &wire.TripDescriptor{RouteId: proto.String("G")}},
+					},
+					{
+						Id:         proto.String("no-trip"),
+						TripUpdate: &wire.TripUpdate{},
+					},
+					{
+						Id: proto.String("good"),
+						TripUpdate: &wire.TripUpdate{
+							Trip: nyctTrip("084421_G..N", proto.String("1G 1404 CHU/CRS")),
+						},
+					},
+				},
+			},
+			expected: TripStatus{
+				Header: expectedHeader,
+				TripUpdates: []TripUpdate{
+					{
+						TripId:         "084421_G..N",
+						RouteId:        "G",
+						TrainId:        "1G 1404 CHU/CRS",
+						IsAssigned:     true,
+						Direction:      &north,
+						StopTimeUpdate: []StopTimeUpdate{},
+					},
+				},
+				VehiclePositions: []VehiclePosition{},
+				Alerts:           []Alert{},
+				DecodeErrors: []DecodeError{
+					{EntityID: "bad", Field: "trip_update.trip.trip_id", Message: "missing trip id, entity skipped", Skipped: true},
+					{EntityID: "no-trip", Field: "trip_update.trip.trip_id", Message: "missing trip id, entity skipped", Skipped: true},
+				},
+			},
+		},
+		{
+			name: "missing optional parts",
+			raw: &wire.FeedMessage{
+				Header: nyctHeader(),
+				Entity: []*wire.FeedEntity{
+					{
+						Id: proto.String("partial"),
+						TripUpdate: &wire.TripUpdate{
+							Trip: &wire.TripDescriptor{TripId: proto.String("084421_G..N")},
+							StopTimeUpdate: []*wire.TripUpdate_StopTimeUpdate{
+								stop(nil, 1689876251, nil),
+								stop(proto.String("F26N"), 1689876375, nil),
+								{StopId: proto.String("F25N"), Arrival: &wire.TripUpdate_StopTimeEvent{}},
+							},
+						},
+					},
+				},
+			},
+			expected: TripStatus{
+				Header: expectedHeader,
+				TripUpdates: []TripUpdate{
+					{
+						TripId: "084421_G..N",
+						StopTimeUpdate: []StopTimeUpdate{
+							{
+								StopID:    "F26N",
+								Arrival:   timePtr(1689876375),
+								Departure: timePtr(1689876375),
+							},
+							{
+								StopID: "F25N",
+							},
+						},
+					},
+				},
+				VehiclePositions: []VehiclePosition{},
+				Alerts:           []Alert{},
+				DecodeErrors: []DecodeError{
+					{EntityID: "partial", Field: "trip_update.trip.nyct_trip_descriptor", Message: "missing NYCT trip descriptor"},
+					{EntityID: "partial", Field: "trip_update.trip.route_id", Message: "missing route id"},
+					{EntityID: "partial", Field: "trip_update.stop_time_update.stop_id", Message: "missing stop id, stop skipped"},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, convertFeedMessage(tc.raw))
+		})
+	}
+}
+
+func TestDecodeError_Error(t *testing.T) {
+	assert.Equal(t, "header: missing feed header", DecodeError{Field: "header", Message: "missing feed header"}.Error())
+	assert.Equal(t, "entity bad: trip_update.trip.trip_id: missing trip id", DecodeError{EntityID: "bad", Field: "trip_update.trip.trip_id", Message: "missing trip id"}.Error())
+}
+
+func TestLiveFeed_Feed(t *testing.T) {
+	validBody, err := proto.Marshal(&wire.FeedMessage{
+		Header: &wire.FeedHeader{GtfsRealtimeVersion: proto.String("1.0")},
+		Entity: []*wire.FeedEntity{
+			{Id: proto.String("1"), TripUpdate: &wire.TripUpdate{Trip: &wire.TripDescriptor{TripId: proto.String("084421_G..N")}}},
+		},
+	})
+	require.NoError(t, err)
+
+	type response struct {
+		status int
+		body   string
+		delay  time.Duration
+	}
+
+	testCases := []struct {
+		name             string
+		responses        []response
+		opts             []LiveFeedOption
+		expectedRequests int
+		expectedStatus   int
+		expectedBody     string
+		expectTimeout    bool
+	}{
+		{
+			name:             "happy path",
+			responses:        []response{{status: http.StatusOK, body: string(validBody)}},
+			expectedRequests: 1,
+		},
+		{
+			name: "transient failures are retried",
+			responses: []response{
+				{status: http.StatusServiceUnavailable, body: "<html>down</html>"},
+				{status: http.StatusTooManyRequests, body: "slow down"},
+				{status: http.StatusOK, body: string(validBody)},
+			},
+			expectedRequests: 3,
+		},
+		{
+			name:             "client errors are not retried",
+			responses:        []response{{status: http.StatusForbidden, body: `{"message":"Forbidden"}`}},
+			expectedRequests: 1,
+			expectedStatus:   http.StatusForbidden,
+			expectedBody:     `{"message":"Forbidden"}`,
+		},
+		{
+			name: "retries are bounded",
+			responses: []response{
+				{status: http.StatusBadGateway, body: "bad gateway"},
+				{status: http.StatusBadGateway, body: "bad gateway"},
+				{status: http.StatusBadGateway, body: "still bad"},
+				{status: http.StatusOK, body: string(validBody)},
+			},
+			expectedRequests: 3,
+			expectedStatus:   http.StatusBadGateway,
+			expectedBody:     "still bad",
+		},
+		{
+			name: "slow responses time out and are retried",
+			responses: []response{
+				{status: http.StatusOK, body: string(validBody), delay: time.Millisecond * 200},
+				{status: http.StatusOK, body: string(validBody)},
+			},
+			opts:             []LiveFeedOption{WithTimeout(time.Millisecond * 20)},
+			expectedRequests: 2,
+		},
+		{
+			name: "timeout without retries",
+			responses: []response{
+				{status: http.StatusOK, body: string(validBody), delay: time.Millisecond * 200},
+			},
+			opts:             []LiveFeedOption{WithTimeout(time.Millisecond * 20), WithRetries(0, 0)},
+			expectedRequests: 1,
+			expectTimeout:    true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+				mutex.Lock()
+				res := tc.responses[requests]
+				requests++
+				mutex.Unlock()
+				if res.delay > 0 {
+					select {
+					case <-time.After(res.delay):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				w.WriteHeader(res.status)
+				_, _ = w.Write([]byte(res.body))
+			}))
+			defer server.Close()
+
+			opts := append([]LiveFeedOption{WithRetries(2, time.Millisecond)}, tc.opts...)
+			status, err := NewLiveFeed(server.URL, "test-key", opts...).Feed(context.Background())
+
+			mutex.Lock()
+			assert.Equal(t, tc.expectedRequests, requests)
+			mutex.Unlock()
+			switch {
+			case tc.expectTimeout:
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			case tc.expectedStatus != 0:
+				var statusErr *StatusError
+				require.ErrorAs(t, err, &statusErr)
+				assert.Equal(t, tc.expectedStatus, statusErr.StatusCode)
+				assert.Equal(t, tc.expectedBody, statusErr.Body)
+				assert.Equal(t, server.URL, statusErr.Endpoint)
+			default:
+				require.NoError(t, err)
+				require.Len(t, status.TripUpdates, 1)
+				assert.Equal(t, "084421_G..N", status.TripUpdates[0].TripId)
+			}
+		})
+	}
+}
+
+func TestLiveFeed_Feed_Staleness(t *testing.T) {
+	feedBody := func(timestamp uint64, tripID string) []byte {
+		b, err := proto.Marshal(&wire.FeedMessage{
+			Header: &wire.FeedHeader{GtfsRealtimeVersion: proto.String("1.0"), Timestamp: proto.Uint64(timestamp)},
+			Entity: []*wire.FeedEntity{
+				{Id: proto.String("1"), TripUpdate: &wire.TripUpdate{Trip: &wire.TripDescriptor{TripId: proto.String(tripID)}}},
+			},
+		})
+		require.NoError(t, err)
+		return b
+	}
+
+	type response struct {
+		status int
+		body   []byte
+	}
+	type exchange struct {
+		expectedIfNoneMatch     string
+		expectedIfModifiedSince string
+		response                response
+		expectedStale           bool
+		expectedTrip            string
+	}
+	exchanges := []exchange{
+		{
+			response:     response{status: http.StatusOK, body: feedBody(1689876248, "first")},
+			expectedTrip: "first",
+		},
+		{
+			expectedIfNoneMatch:     `"v1"`,
+			expectedIfModifiedSince: "Thu, 20 Jul 2023 18:04:08 GMT",
+			response:                response{status: http.StatusNotModified},
+			expectedStale:           true,
+			expectedTrip:            "first",
+		},
+		{
+			// a lagging cache node serving an older snapshot
+			expectedIfNoneMatch:     `"v1"`,
+			expectedIfModifiedSince: "Thu, 20 Jul 2023 18:04:08 GMT",
+			response:                response{status: http.StatusOK, body: feedBody(1689876200, "older")},
+			expectedStale:           true,
+			expectedTrip:            "older",
+		},
+		{
+			expectedIfNoneMatch:     `"v1"`,
+			expectedIfModifiedSince: "Thu, 20 Jul 2023 18:04:08 GMT",
+			response:                response{status: http.StatusOK, body: feedBody(1689876278, "second")},
+			expectedTrip:            "second",
+		},
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ex := exchanges[requests]
+		requests++
+		assert.Equal(t, ex.expectedIfNoneMatch, r.Header.Get("If-None-Match"))
+		assert.Equal(t, ex.expectedIfModifiedSince, r.Header.Get("If-Modified-Since"))
+		if requests == 1 {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Thu, 20 Jul 2023 18:04:08 GMT")
+		}
+		w.WriteHeader(ex.response.status)
+		_, _ = w.Write(ex.response.body)
+	}))
+	defer server.Close()
+
+	feed := NewLiveFeed(server.URL, "test-key", WithName("g"))
+	assert.Equal(t, "g", feed.Name())
+	for i, ex := range exchanges {
+		status, err := feed.Feed(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, ex.expectedStale, status.Stale, "exchange %d", i)
+		require.Len(t, status.TripUpdates, 1)
+		assert.Equal(t, ex.expectedTrip, status.TripUpdates[0].TripId, "exchange %d", i)
+	}
+	assert.Equal(t, len(exchanges), requests)
+}
+
+func TestLiveFeed_Feed_Concurrent(t *testing.T) {
+	body, err := proto.Marshal(&wire.FeedMessage{
+		Header: &wire.FeedHeader{GtfsRealtimeVersion: proto.String("1.0"), Timestamp: proto.Uint64(1689876248)},
+	})
+	require.NoError(t, err)
+
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// a slow pull must not hold up the next one
+	feed := NewLiveFeed(server.URL, "test-key")
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := feed.Feed(context.Background())
+			results <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(time.Second * 5):
+			require.Fail(t, "pulls were serialized")
+		}
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-results)
+	}
+}
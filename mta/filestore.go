@@ -0,0 +1,104 @@
+package mta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// FileStore is a StateStore that persists state as JSON to a file on disk, allowing in-flight trips to survive a
+// restart. Each call to RecordState replaces the file atomically by writing to a temp file and renaming it into place,
+// so a crash mid-write leaves the previous state intact.
+type FileStore struct {
+	mutex      sync.RWMutex
+	path       string
+	loaded     bool
+	worldState []TripUpdate
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+func (f *FileStore) PriorState(ctx context.Context) ([]TripUpdate, error) {
+	f.mutex.RLock()
+	if f.loaded {
+		defer f.mutex.RUnlock()
+		return f.worldState, nil
+	}
+	f.mutex.RUnlock()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.loaded {
+		return f.worldState, nil
+	}
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		zerolog.Ctx(ctx).Info().Str("path", f.path).Msg("no prior state on disk, starting fresh")
+		f.loaded = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state []TripUpdate
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().Str("path", f.path).Int("trips", len(state)).Msg("loaded prior state from disk")
+	f.worldState = state
+	f.loaded = true
+	return f.worldState, nil
+}
+
+func (f *FileStore) RecordState(ctx context.Context, state []TripUpdate) error {
+	zerolog.Ctx(ctx).Debug().Int("trips", len(state)).Str("path", f.path).Msg("persisting state to disk")
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	err = writeFileAtomic(f.path, b)
+	if err != nil {
+		return err
+	}
+	f.worldState = state
+	f.loaded = true
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// cleanup is a no-op once the rename has happened
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
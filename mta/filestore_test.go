@@ -0,0 +1,60 @@
+package mta
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	arrival := time.Unix(1689876251, 0)
+	direction := DirectionNorth
+	track := "B2"
+	state := []TripUpdate{
+		{
+			TripId:     "084421_G..N",
+			RouteId:    "G",
+			TrainId:    "1G 1404 CHU/CRS",
+			IsAssigned: true,
+			Direction:  &direction,
+			StopTimeUpdate: []StopTimeUpdate{
+				{
+					StopID:         "F27N",
+					Arrival:        &arrival,
+					Departure:      &arrival,
+					ScheduledTrack: &track,
+					ActualTrack:    &track,
+					IsComplete:     true,
+				},
+			},
+		},
+	}
+
+	prior, err := NewFileStore(path).PriorState(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, prior)
+
+	err = NewFileStore(path).RecordState(ctx, state)
+	require.NoError(t, err)
+
+	// a fresh instance simulates a restart of the process
+	reloaded, err := NewFileStore(path).PriorState(ctx)
+	require.NoError(t, err)
+	require.Len(t, reloaded, 1)
+	assert.Equal(t, state[0].TripId, reloaded[0].TripId)
+	assert.Equal(t, state[0].Direction, reloaded[0].Direction)
+	require.Len(t, reloaded[0].StopTimeUpdate, 1)
+	assert.True(t, reloaded[0].StopTimeUpdate[0].IsComplete)
+	assert.True(t, arrival.Equal(*reloaded[0].StopTimeUpdate[0].Arrival))
+
+	matches, err := filepath.Glob(path + ".*.tmp")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
@@ -22,20 +22,18 @@ func (_m *MockStateOracle) EXPECT() *MockStateOracle_Expecter {
 }
 
 // CurrentState provides a mock function with given fields: ctx
-func (_m *MockStateOracle) CurrentState(ctx context.Context) ([]TripUpdate, error) {
+func (_m *MockStateOracle) CurrentState(ctx context.Context) (SystemState, error) {
 	ret := _m.Called(ctx)
 
-	var r0 []TripUpdate
+	var r0 SystemState
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) ([]TripUpdate, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context) (SystemState, error)); ok {
 		return rf(ctx)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) []TripUpdate); ok {
+	if rf, ok := ret.Get(0).(func(context.Context) SystemState); ok {
 		r0 = rf(ctx)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]TripUpdate)
-		}
+		r0 = ret.Get(0).(SystemState)
 	}
 
 	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
@@ -65,12 +63,12 @@ func (_c *MockStateOracle_CurrentState_Call) Run(run func(ctx context.Context))
 	return _c
 }
 
-func (_c *MockStateOracle_CurrentState_Call) Return(_a0 []TripUpdate, _a1 error) *MockStateOracle_CurrentState_Call {
+func (_c *MockStateOracle_CurrentState_Call) Return(_a0 SystemState, _a1 error) *MockStateOracle_CurrentState_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockStateOracle_CurrentState_Call) RunAndReturn(run func(context.Context) ([]TripUpdate, error)) *MockStateOracle_CurrentState_Call {
+func (_c *MockStateOracle_CurrentState_Call) RunAndReturn(run func(context.Context) (SystemState, error)) *MockStateOracle_CurrentState_Call {
 	_c.Call.Return(run)
 	return _c
 }
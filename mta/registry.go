@@ -0,0 +1,205 @@
+package mta
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const mtaFeedBaseURL = "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/"
+
+// FeedDefinition describes a GTFS-realtime feed and the routes it carries
+type FeedDefinition struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Routes []string `json:"routes,omitempty"`
+	// Alerts is true for feeds carrying service alerts rather than trip updates
+	Alerts bool `json:"alerts,omitempty"`
+}
+
+func (d FeedDefinition) serves(routeID string) bool {
+	for _, r := range d.Routes {
+		if r == routeID {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedRegistry is a named set of feeds that can be selected from by name or by the routes they serve
+type FeedRegistry struct {
+	feeds []FeedDefinition
+}
+
+func NewFeedRegistry(feeds ...FeedDefinition) *FeedRegistry {
+	return &FeedRegistry{
+		feeds: feeds,
+	}
+}
+
+// DefaultFeedRegistry contains all the NYCT subway feeds published by the MTA, plus the subway service alerts feed
+func DefaultFeedRegistry() *FeedRegistry {
+	return NewFeedRegistry(
+		FeedDefinition{Name: "ace", URL: mtaFeedBaseURL + "nyct%2Fgtfs-ace", Routes: []string{"A", "C", "E", "H", "FS"}},
+		FeedDefinition{Name: "bdfm", URL: mtaFeedBaseURL + "nyct%2Fgtfs-bdfm", Routes: []string{"B", "D", "F", "FX", "M"}},
+		FeedDefinition{Name: "g", URL: mtaFeedBaseURL + "nyct%2Fgtfs-g", Routes: []string{"G"}},
+		FeedDefinition{Name: "jz", URL: mtaFeedBaseURL + "nyct%2Fgtfs-jz", Routes: []string{"J", "Z"}},
+		FeedDefinition{Name: "nqrw", URL: mtaFeedBaseURL + "nyct%2Fgtfs-nqrw", Routes: []string{"N", "Q", "R", "W"}},
+		FeedDefinition{Name: "l", URL: mtaFeedBaseURL + "nyct%2Fgtfs-l", Routes: []string{"L"}},
+		FeedDefinition{Name: "1234567", URL: mtaFeedBaseURL + "nyct%2Fgtfs", Routes: []string{"1", "2", "3", "4", "5", "5X", "6", "6X", "7", "7X", "GS"}},
+		FeedDefinition{Name: "si", URL: mtaFeedBaseURL + "nyct%2Fgtfs-si", Routes: []string{"SI"}},
+		FeedDefinition{Name: "alerts", URL: mtaFeedBaseURL + "camsys%2Fsubway-alerts", Alerts: true},
+	)
+}
+
+// LoadFeedDefinitions reads a JSON array of feed definitions from the file at path
+func LoadFeedDefinitions(path string) ([]FeedDefinition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []FeedDefinition
+	err = json.Unmarshal(b, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("parsing feed definitions in %s: %w", path, err)
+	}
+	for i, d := range ret {
+		if d.Name == "" {
+			return nil, fmt.Errorf("feed definition %d in %s has no name", i, path)
+		}
+	}
+	return ret, nil
+}
+
+// WithOverrides returns a new registry with the given definitions replacing any of the same name. Definitions with new
+// names are added. Fields left empty in an override are taken from the definition it replaces, so pointing a feed at a
+// local stand-in server only requires the name and url. An override can't turn an alerts feed into a trip feed.
+func (r *FeedRegistry) WithOverrides(overrides ...FeedDefinition) *FeedRegistry {
+	feeds := make([]FeedDefinition, len(r.feeds))
+	copy(feeds, r.feeds)
+	for _, o := range overrides {
+		replaced := false
+		for i, existing := range feeds {
+			if existing.Name != o.Name {
+				continue
+			}
+			if o.URL == "" {
+				o.URL = existing.URL
+			}
+			if o.Routes == nil {
+				o.Routes = existing.Routes
+			}
+			o.Alerts = o.Alerts || existing.Alerts
+			feeds[i] = o
+			replaced = true
+		}
+		if !replaced {
+			feeds = append(feeds, o)
+		}
+	}
+	return NewFeedRegistry(feeds...)
+}
+
+// All returns every feed in the registry
+func (r *FeedRegistry) All() []FeedDefinition {
+	ret := make([]FeedDefinition, len(r.feeds))
+	copy(ret, r.feeds)
+	return ret
+}
+
+// TripFeeds returns every feed carrying trip updates
+func (r *FeedRegistry) TripFeeds() []FeedDefinition {
+	ret := make([]FeedDefinition, 0, len(r.feeds))
+	for _, f := range r.feeds {
+		if !f.Alerts {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// ByName returns the named feeds, erroring if any are unknown
+func (r *FeedRegistry) ByName(names ...string) ([]FeedDefinition, error) {
+	ret := make([]FeedDefinition, 0, len(names))
+	for _, n := range names {
+		found := false
+		for _, f := range r.feeds {
+			if f.Name == n {
+				ret = append(ret, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown feed %q", n)
+		}
+	}
+	return ret, nil
+}
+
+// ForRoutes returns the trip feeds needed to cover the given routes, erroring if any route is not served by any of them
+func (r *FeedRegistry) ForRoutes(routeIDs ...string) ([]FeedDefinition, error) {
+	needed := make(map[string]bool)
+	for _, route := range routeIDs {
+		found := false
+		for _, f := range r.feeds {
+			if !f.Alerts && f.serves(route) {
+				needed[f.Name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no feed serves route %q", route)
+		}
+	}
+	// walk the registry rather than the map to keep registry order
+	ret := make([]FeedDefinition, 0, len(needed))
+	for _, f := range r.feeds {
+		if needed[f.Name] {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+// Select returns the union of the named feeds and the feeds serving the given routes, in registry order. If neither
+// names nor routes are given all trip feeds are returned. Only trip feeds can be selected, naming an alerts feed is an
+// error.
+func (r *FeedRegistry) Select(names []string, routeIDs []string) ([]FeedDefinition, error) {
+	if len(names) == 0 && len(routeIDs) == 0 {
+		return r.TripFeeds(), nil
+	}
+	byName, err := r.ByName(names...)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range byName {
+		if f.Alerts {
+			return nil, fmt.Errorf("feed %q carries alerts rather than trip updates", f.Name)
+		}
+	}
+	byRoute, err := r.ForRoutes(routeIDs...)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool)
+	for _, f := range append(byName, byRoute...) {
+		selected[f.Name] = true
+	}
+	ret := make([]FeedDefinition, 0, len(selected))
+	for _, f := range r.feeds {
+		if selected[f.Name] {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+// NewLiveFeeds creates a LiveFeed named after each definition
+func NewLiveFeeds(defs []FeedDefinition, apiKey string, opts ...LiveFeedOption) []Feed {
+	ret := make([]Feed, len(defs))
+	for i, d := range defs {
+		ret[i] = NewLiveFeed(d.URL, apiKey, append([]LiveFeedOption{WithName(d.Name)}, opts...)...)
+	}
+	return ret
+}
@@ -0,0 +1,121 @@
+package mta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func feedNames(defs []FeedDefinition) []string {
+	ret := make([]string, len(defs))
+	for i, d := range defs {
+		ret[i] = d.Name
+	}
+	return ret
+}
+
+func TestFeedRegistry_Select(t *testing.T) {
+	testCases := []struct {
+		name          string
+		names         []string
+		routes        []string
+		expected      []string
+		expectedError string
+	}{
+		{
+			name:     "everything with trips by default",
+			expected: []string{"ace", "bdfm", "g", "jz", "nqrw", "l", "1234567", "si"},
+		},
+		{
+			name:     "by route",
+			routes:   []string{"G", "A", "C"},
+			expected: []string{"ace", "g"},
+		},
+		{
+			name:     "by name and route",
+			names:    []string{"l"},
+			routes:   []string{"L", "7"},
+			expected: []string{"l", "1234567"},
+		},
+		{
+			name:          "alerts feed",
+			names:         []string{"alerts", "l"},
+			expectedError: `feed "alerts" carries alerts rather than trip updates`,
+		},
+		{
+			name:          "unknown route",
+			routes:        []string{"K"},
+			expectedError: `no feed serves route "K"`,
+		},
+		{
+			name:          "unknown name",
+			names:         []string{"kl"},
+			expectedError: `unknown feed "kl"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DefaultFeedRegistry().Select(tc.names, tc.routes)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, feedNames(got))
+		})
+	}
+}
+
+func TestFeedRegistry_WithOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feeds.json")
+	err := os.WriteFile(path, []byte(`[
+		{"name": "g", "url": "http://localhost:8080/g"},
+		{"name": "local", "url": "http://localhost:8080/local", "routes": ["K"]}
+	]`), 0644)
+	require.NoError(t, err)
+
+	overrides, err := LoadFeedDefinitions(path)
+	require.NoError(t, err)
+	registry := DefaultFeedRegistry().WithOverrides(overrides...)
+
+	got, err := registry.ForRoutes("G", "K")
+	require.NoError(t, err)
+	assert.Equal(t, []FeedDefinition{
+		{Name: "g", URL: "http://localhost:8080/g", Routes: []string{"G"}},
+		{Name: "local", URL: "http://localhost:8080/local", Routes: []string{"K"}},
+	}, got)
+
+	// the default registry is left untouched
+	orig, err := DefaultFeedRegistry().ByName("g")
+	require.NoError(t, err)
+	assert.Equal(t, mtaFeedBaseURL+"nyct%2Fgtfs-g", orig[0].URL)
+
+	feeds := NewLiveFeeds(got, "key")
+	require.Len(t, feeds, 2)
+	assert.Equal(t, "g", feeds[0].Name())
+	assert.Equal(t, "local", feeds[1].Name())
+}
+
+func TestFeedRegistry_WithOverrides_Alerts(t *testing.T) {
+	registry := DefaultFeedRegistry().WithOverrides(FeedDefinition{Name: "alerts", URL: "http://localhost:8080/alerts"})
+
+	alerts, err := registry.ByName("alerts")
+	require.NoError(t, err)
+	assert.Equal(t, []FeedDefinition{{Name: "alerts", URL: "http://localhost:8080/alerts", Alerts: true}}, alerts)
+
+	// still not a trip feed, so it stays out of the default selection
+	selected, err := registry.Select(nil, nil)
+	require.NoError(t, err)
+	for _, f := range selected {
+		assert.NotEqual(t, "alerts", f.Name)
+	}
+	assert.Len(t, selected, len(DefaultFeedRegistry().TripFeeds()))
+
+	// nor is it picked up for a route it has been given
+	byRoute, err := registry.WithOverrides(FeedDefinition{Name: "alerts", Routes: []string{"G"}}).ForRoutes("G")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"g"}, feedNames(byRoute))
+}
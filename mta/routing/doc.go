@@ -0,0 +1,4 @@
+package routing
+
+// Routing: answers "what is the fastest way from stop X to stop Y leaving now" by riding the trains currently in the
+// feeds, changing trains where transfers.txt (and optionally station complexes) allow.
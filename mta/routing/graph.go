@@ -0,0 +1,186 @@
+package routing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+)
+
+const (
+	// DefaultComplexTransferTime is used for transfers between the stations of a complex that transfers.txt doesn't
+	// cover, matching the most common min_transfer_time in the MTA's data
+	DefaultComplexTransferTime = 3 * time.Minute
+	// observedWeight is how much each observed segment moves the run time between its stops, so recent conditions win
+	// out without one slow train swinging things too far
+	observedWeight = 0.25
+)
+
+type hop struct {
+	from string
+	to   string
+}
+
+// runTime is how long trains take between two stops, as scheduled and as observed
+type runTime struct {
+	scheduledTotal time.Duration
+	scheduledCount int
+	observed       time.Duration
+	observedCount  int
+}
+
+func (r *runTime) estimate() (time.Duration, bool) {
+	if r.observedCount > 0 {
+		return r.observed, true
+	}
+	if r.scheduledCount > 0 {
+		return r.scheduledTotal / time.Duration(r.scheduledCount), true
+	}
+	return 0, false
+}
+
+type transfer struct {
+	to       string
+	duration time.Duration
+}
+
+// Graph is the structure of the system: the run times between stops, taken from the static stop sequences and refined
+// by observed segments, and the transfers that can be made between platforms
+type Graph struct {
+	mutex     sync.RWMutex
+	platforms map[string][]string
+	runTimes  map[hop]*runTime
+	transfers map[string][]transfer
+
+	stations            *static.StationIndex
+	complexTransferTime time.Duration
+}
+
+type GraphOption func(g *Graph)
+
+// WithComplexTransfers allows free transfers between the stations of a complex (see static.StationIndex.Complex) when
+// transfers.txt doesn't already, taking the given time
+func WithComplexTransfers(stations *static.StationIndex, transferTime time.Duration) GraphOption {
+	return func(g *Graph) {
+		g.stations = stations
+		g.complexTransferTime = transferTime
+	}
+}
+
+// NewGraph builds the graph from the schedule's stops, stop times and transfers
+func NewGraph(schedule *static.Schedule, opts ...GraphOption) *Graph {
+	ret := &Graph{
+		platforms:           make(map[string][]string),
+		runTimes:            make(map[hop]*runTime),
+		transfers:           make(map[string][]transfer),
+		complexTransferTime: DefaultComplexTransferTime,
+	}
+	for _, o := range opts {
+		o(ret)
+	}
+
+	for _, stop := range schedule.Stops {
+		if stop.ParentStation != "" {
+			ret.platforms[stop.ParentStation] = append(ret.platforms[stop.ParentStation], stop.StopID)
+		}
+	}
+
+	for _, trip := range schedule.Trips {
+		stopTimes := schedule.StopTimesFor(trip.TripID)
+		for i := 1; i < len(stopTimes); i++ {
+			prev, cur := stopTimes[i-1], stopTimes[i]
+			rt := ret.runTime(hop{from: prev.StopID, to: cur.StopID})
+			rt.scheduledTotal += time.Duration(cur.ArrivalTime - prev.DepartureTime)
+			rt.scheduledCount++
+		}
+	}
+
+	linked := make(map[hop]bool)
+	for _, t := range schedule.Transfers {
+		// transfer type 3 means a transfer isn't possible, 0 and 1 have no minimum time
+		if t.TransferType == 3 {
+			continue
+		}
+		ret.link(t.FromStopID, t.ToStopID, time.Duration(t.MinTransferTimeSecs)*time.Second)
+		linked[hop{from: t.FromStopID, to: t.ToStopID}] = true
+	}
+	if ret.stations != nil {
+		for _, c := range ret.stations.Complexes() {
+			for _, from := range c.Stations {
+				for _, to := range c.Stations {
+					if from.GTFSID == to.GTFSID || linked[hop{from: from.GTFSID, to: to.GTFSID}] {
+						continue
+					}
+					ret.link(from.GTFSID, to.GTFSID, ret.complexTransferTime)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func (g *Graph) runTime(h hop) *runTime {
+	rt, ok := g.runTimes[h]
+	if !ok {
+		rt = &runTime{}
+		g.runTimes[h] = rt
+	}
+	return rt
+}
+
+// link adds transfers between every platform of one stop and every platform of another
+func (g *Graph) link(fromStop, toStop string, duration time.Duration) {
+	for _, from := range g.platformsOf(fromStop) {
+		for _, to := range g.platformsOf(toStop) {
+			if from == to {
+				continue
+			}
+			g.transfers[from] = append(g.transfers[from], transfer{to: to, duration: duration})
+		}
+	}
+}
+
+// platformsOf returns the platforms of a parent stop. Stops the schedule doesn't know the platforms of are assumed to
+// follow the NYCT convention of the parent ID plus a direction.
+func (g *Graph) platformsOf(stopID string) []string {
+	if p, ok := g.platforms[stopID]; ok {
+		return p
+	}
+	return []string{stopID + "N", stopID + "S"}
+}
+
+// ObserveSegments refines the run times between stops with how long trains actually took
+func (g *Graph) ObserveSegments(segments []mta.Segment) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, s := range segments {
+		d := s.ArriveAt.Sub(s.DepartAt)
+		if d <= 0 {
+			continue
+		}
+		rt := g.runTime(hop{from: s.FromStation, to: s.ToStation})
+		if rt.observedCount == 0 {
+			rt.observed = d
+		} else {
+			rt.observed += time.Duration(observedWeight * float64(d-rt.observed))
+		}
+		rt.observedCount++
+	}
+}
+
+// RunTime is how long trains are expected to take from one stop to the next, based on observed segments if there have
+// been any and the schedule otherwise
+func (g *Graph) RunTime(from, to string) (time.Duration, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.runTimeLocked(from, to)
+}
+
+func (g *Graph) runTimeLocked(from, to string) (time.Duration, bool) {
+	rt, ok := g.runTimes[hop{from: from, to: to}]
+	if !ok {
+		return 0, false
+	}
+	return rt.estimate()
+}
@@ -0,0 +1,251 @@
+package routing
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+)
+
+// ErrNoPath is returned when none of the trains in flight can get from one stop to the other
+var ErrNoPath = errors.New("no path found")
+
+type LegType string
+
+const (
+	// LegRide is a ride on a single trip
+	LegRide LegType = "ride"
+	// LegTransfer is a walk between platforms
+	LegTransfer LegType = "transfer"
+)
+
+type Leg struct {
+	Type     LegType   `json:"type"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	DepartAt time.Time `json:"departAt"`
+	ArriveAt time.Time `json:"arriveAt"`
+	TripID   string    `json:"tripId,omitempty"`
+	RouteID  string    `json:"routeId,omitempty"`
+	// Estimated is true when ArriveAt was worked out from run times rather than taken from the feed's predictions
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// Path is a journey from one stop to another, made of alternating rides and transfers
+type Path struct {
+	DepartAt time.Time `json:"departAt"`
+	ArriveAt time.Time `json:"arriveAt"`
+	Legs     []Leg     `json:"legs"`
+}
+
+// Duration is how long the journey takes, from DepartAt (rather than the first train leaving) to arrival
+func (p Path) Duration() time.Duration {
+	return p.ArriveAt.Sub(p.DepartAt)
+}
+
+// tripStop is a stop a trip in flight has yet to leave
+type tripStop struct {
+	stopID    string
+	arriveAt  time.Time
+	departAt  time.Time
+	estimated bool
+}
+
+type liveTrip struct {
+	tripID  string
+	routeID string
+	stops   []tripStop
+}
+
+type boarding struct {
+	trip     *liveTrip
+	position int
+}
+
+// FastestPath finds the journey from one stop to another, leaving at departAt, that arrives soonest riding the given
+// trips. Stops may be parent stops (F27), in which case any of their platforms will do, or platforms (F27N).
+func (g *Graph) FastestPath(from, to string, departAt time.Time, trips []mta.TripUpdate) (Path, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	boardings := make(map[string][]boarding)
+	for _, t := range trips {
+		lt := g.timeline(t)
+		for i := range lt.stops {
+			boardings[lt.stops[i].stopID] = append(boardings[lt.stops[i].stopID], boarding{trip: lt, position: i})
+		}
+	}
+
+	destinations := make(map[string]bool)
+	for _, p := range g.endpoints(to) {
+		destinations[p] = true
+	}
+
+	best := make(map[string]time.Time)
+	via := make(map[string]Leg)
+	queue := &arrivalQueue{}
+	for _, p := range g.endpoints(from) {
+		best[p] = departAt
+		heap.Push(queue, arrival{stopID: p, at: departAt})
+	}
+	relax := func(leg Leg) {
+		if known, ok := best[leg.To]; ok && !leg.ArriveAt.Before(known) {
+			return
+		}
+		best[leg.To] = leg.ArriveAt
+		via[leg.To] = leg
+		heap.Push(queue, arrival{stopID: leg.To, at: leg.ArriveAt})
+	}
+
+	for queue.Len() > 0 {
+		cur := heap.Pop(queue).(arrival)
+		if cur.at.After(best[cur.stopID]) {
+			// superseded by a quicker way here
+			continue
+		}
+		if destinations[cur.stopID] {
+			return buildPath(departAt, cur.stopID, via), nil
+		}
+		for _, t := range g.transfers[cur.stopID] {
+			relax(Leg{
+				Type:     LegTransfer,
+				From:     cur.stopID,
+				To:       t.to,
+				DepartAt: cur.at,
+				ArriveAt: cur.at.Add(t.duration),
+			})
+		}
+		for _, b := range boardings[cur.stopID] {
+			board := b.trip.stops[b.position]
+			if board.departAt.Before(cur.at) {
+				continue
+			}
+			for _, alight := range b.trip.stops[b.position+1:] {
+				relax(Leg{
+					Type:      LegRide,
+					From:      cur.stopID,
+					To:        alight.stopID,
+					DepartAt:  board.departAt,
+					ArriveAt:  alight.arriveAt,
+					TripID:    b.trip.tripID,
+					RouteID:   b.trip.routeID,
+					Estimated: alight.estimated,
+				})
+			}
+		}
+	}
+	return Path{}, ErrNoPath
+}
+
+// endpoints returns the platforms a journey can start or end at for the given stop
+func (g *Graph) endpoints(stopID string) []string {
+	if p, ok := g.platforms[stopID]; ok {
+		return p
+	}
+	// either a platform, or a parent stop the schedule doesn't know about, see platformsOf
+	return append([]string{stopID}, g.platformsOf(stopID)...)
+}
+
+// timeline works out when the trip will be at each of the stops it has yet to leave. Stops without predicted times are
+// estimated from the run time from the stop before, and the trip is cut short at the first stop that can't be.
+// Predictions aren't always consistent with each other, so times are pushed back where needed to keep the train from
+// arriving anywhere before it left the stop before, or leaving a stop before it got there.
+func (g *Graph) timeline(t mta.TripUpdate) *liveTrip {
+	ret := &liveTrip{
+		tripID:  t.TripId,
+		routeID: t.RouteId,
+		stops:   make([]tripStop, 0, len(t.StopTimeUpdate)),
+	}
+	for _, stu := range t.StopTimeUpdate {
+		if stu.IsComplete {
+			continue
+		}
+		stop := tripStop{stopID: stu.StopID}
+		switch {
+		case stu.Arrival != nil && stu.Departure != nil:
+			stop.arriveAt, stop.departAt = *stu.Arrival, *stu.Departure
+		case stu.Arrival != nil:
+			stop.arriveAt, stop.departAt = *stu.Arrival, *stu.Arrival
+		case stu.Departure != nil:
+			stop.arriveAt, stop.departAt = *stu.Departure, *stu.Departure
+		default:
+			if len(ret.stops) == 0 {
+				return ret
+			}
+			prev := ret.stops[len(ret.stops)-1]
+			d, ok := g.runTimeLocked(prev.stopID, stu.StopID)
+			if !ok {
+				return ret
+			}
+			stop.arriveAt = prev.departAt.Add(d)
+			stop.departAt = stop.arriveAt
+			stop.estimated = true
+		}
+		if len(ret.stops) > 0 {
+			if prev := ret.stops[len(ret.stops)-1]; stop.arriveAt.Before(prev.departAt) {
+				stop.arriveAt = prev.departAt
+			}
+		}
+		if stop.departAt.Before(stop.arriveAt) {
+			stop.departAt = stop.arriveAt
+		}
+		ret.stops = append(ret.stops, stop)
+	}
+	return ret
+}
+
+func buildPath(departAt time.Time, dest string, via map[string]Leg) Path {
+	legs := make([]Leg, 0)
+	for stopID := dest; ; {
+		leg, ok := via[stopID]
+		if !ok {
+			break
+		}
+		legs = append(legs, leg)
+		stopID = leg.From
+	}
+	for i, j := 0, len(legs)-1; i < j; i, j = i+1, j-1 {
+		legs[i], legs[j] = legs[j], legs[i]
+	}
+	ret := Path{
+		DepartAt: departAt,
+		ArriveAt: departAt,
+		Legs:     legs,
+	}
+	if len(legs) > 0 {
+		ret.ArriveAt = legs[len(legs)-1].ArriveAt
+	}
+	return ret
+}
+
+type arrival struct {
+	stopID string
+	at     time.Time
+}
+
+// arrivalQueue is a min heap of arrivals, soonest first
+type arrivalQueue []arrival
+
+func (q arrivalQueue) Len() int {
+	return len(q)
+}
+
+func (q arrivalQueue) Less(i, j int) bool {
+	return q[i].at.Before(q[j].at)
+}
+
+func (q arrivalQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *arrivalQueue) Push(x any) {
+	*q = append(*q, x.(arrival))
+}
+
+func (q *arrivalQueue) Pop() any {
+	old := *q
+	ret := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ret
+}
@@ -0,0 +1,287 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/jonsabados/mta2furious/mta/static"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtures = "../static/fixtures/"
+
+func TestGraph_FastestPath(t *testing.T) {
+	schedule, err := static.Load(fixtures)
+	require.NoError(t, err)
+	stationRecords, err := static.LoadStations(fixtures + "Stations.csv")
+	require.NoError(t, err)
+	stations := static.NewStationIndex(schedule, stationRecords)
+
+	now := time.Date(2023, 7, 23, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ret := now.Add(d)
+		return &ret
+	}
+
+	southbound := mta.TripUpdate{
+		TripId:  "060000_1..S03R",
+		RouteId: "1",
+		StopTimeUpdate: []mta.StopTimeUpdate{
+			{StopID: "101S", Departure: at(time.Minute)},
+			{StopID: "103S", Arrival: at(150 * time.Second), Departure: at(160 * time.Second)},
+			// no prediction, the scheduled 90 seconds from 103S is used
+			{StopID: "104S"},
+		},
+	}
+	northbound := mta.TripUpdate{
+		TripId:  "059000_1..N03R",
+		RouteId: "1",
+		StopTimeUpdate: []mta.StopTimeUpdate{
+			{StopID: "104N", Arrival: at(-2 * time.Minute), Departure: at(-2 * time.Minute), IsComplete: true},
+			{StopID: "103N", Arrival: at(30 * time.Second), Departure: at(40 * time.Second)},
+			{StopID: "101N", Arrival: at(3 * time.Minute)},
+		},
+	}
+	// R and 6 trains meeting at the Lexington Av/59 St complex, which transfers.txt in the fixtures doesn't cover
+	rTrain := mta.TripUpdate{
+		TripId:  "060000_R..S",
+		RouteId: "R",
+		StopTimeUpdate: []mta.StopTimeUpdate{
+			{StopID: "R15S", Departure: at(0)},
+			{StopID: "R11S", Arrival: at(4 * time.Minute)},
+		},
+	}
+	sixTrain := mta.TripUpdate{
+		TripId:  "060000_6..S",
+		RouteId: "6",
+		StopTimeUpdate: []mta.StopTimeUpdate{
+			{StopID: "629S", Departure: at(8 * time.Minute)},
+			{StopID: "631S", Arrival: at(10 * time.Minute)},
+		},
+	}
+	trips := []mta.TripUpdate{southbound, northbound, rTrain, sixTrain}
+
+	testCases := []struct {
+		name          string
+		opts          []GraphOption
+		observed      []mta.Segment
+		from          string
+		to            string
+		departAt      time.Time
+		expected      Path
+		expectedError error
+	}{
+		{
+			name:     "single ride with an estimated arrival",
+			from:     "101",
+			to:       "104",
+			departAt: now,
+			expected: Path{
+				DepartAt: now,
+				ArriveAt: now.Add(250 * time.Second),
+				Legs: []Leg{{
+					Type:      LegRide,
+					From:      "101S",
+					To:        "104S",
+					DepartAt:  now.Add(time.Minute),
+					ArriveAt:  now.Add(250 * time.Second),
+					TripID:    "060000_1..S03R",
+					RouteID:   "1",
+					Estimated: true,
+				}},
+			},
+		},
+		{
+			name: "observed run times are preferred over the schedule",
+			observed: []mta.Segment{{
+				FromStation: "103S",
+				ToStation:   "104S",
+				DepartAt:    now.Add(-time.Hour),
+				ArriveAt:    now.Add(-time.Hour + 2*time.Minute),
+			}},
+			from:     "103S",
+			to:       "104",
+			departAt: now,
+			expected: Path{
+				DepartAt: now,
+				ArriveAt: now.Add(280 * time.Second),
+				Legs: []Leg{{
+					Type:      LegRide,
+					From:      "103S",
+					To:        "104S",
+					DepartAt:  now.Add(160 * time.Second),
+					ArriveAt:  now.Add(280 * time.Second),
+					TripID:    "060000_1..S03R",
+					RouteID:   "1",
+					Estimated: true,
+				}},
+			},
+		},
+		{
+			name:     "ride then transfer between platforms",
+			from:     "103N",
+			to:       "101S",
+			departAt: now,
+			expected: Path{
+				DepartAt: now,
+				ArriveAt: now.Add(6 * time.Minute),
+				Legs: []Leg{{
+					Type:     LegRide,
+					From:     "103N",
+					To:       "101N",
+					DepartAt: now.Add(40 * time.Second),
+					ArriveAt: now.Add(3 * time.Minute),
+					TripID:   "059000_1..N03R",
+					RouteID:  "1",
+				}, {
+					Type:     LegTransfer,
+					From:     "101N",
+					To:       "101S",
+					DepartAt: now.Add(3 * time.Minute),
+					ArriveAt: now.Add(6 * time.Minute),
+				}},
+			},
+		},
+		{
+			name:          "train already gone",
+			from:          "101",
+			to:            "104",
+			departAt:      now.Add(2 * time.Minute),
+			expectedError: ErrNoPath,
+		},
+		{
+			name:          "completed stops can't be boarded at",
+			from:          "104N",
+			to:            "101",
+			departAt:      now.Add(-5 * time.Minute),
+			expectedError: ErrNoPath,
+		},
+		{
+			name:          "complexes need enabling",
+			from:          "R15",
+			to:            "631",
+			departAt:      now,
+			expectedError: ErrNoPath,
+		},
+		{
+			name:     "transfer within a complex",
+			opts:     []GraphOption{WithComplexTransfers(stations, 3*time.Minute)},
+			from:     "R15",
+			to:       "631",
+			departAt: now,
+			expected: Path{
+				DepartAt: now,
+				ArriveAt: now.Add(10 * time.Minute),
+				Legs: []Leg{{
+					Type:     LegRide,
+					From:     "R15S",
+					To:       "R11S",
+					DepartAt: now,
+					ArriveAt: now.Add(4 * time.Minute),
+					TripID:   "060000_R..S",
+					RouteID:  "R",
+				}, {
+					Type:     LegTransfer,
+					From:     "R11S",
+					To:       "629S",
+					DepartAt: now.Add(4 * time.Minute),
+					ArriveAt: now.Add(7 * time.Minute),
+				}, {
+					Type:     LegRide,
+					From:     "629S",
+					To:       "631S",
+					DepartAt: now.Add(8 * time.Minute),
+					ArriveAt: now.Add(10 * time.Minute),
+					TripID:   "060000_6..S",
+					RouteID:  "6",
+				}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph := NewGraph(schedule, tc.opts...)
+			graph.ObserveSegments(tc.observed)
+			path, err := graph.FastestPath(tc.from, tc.to, tc.departAt, trips)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, path)
+		})
+	}
+}
+
+func TestGraph_FastestPath_InconsistentPredictions(t *testing.T) {
+	schedule, err := static.Load(fixtures)
+	require.NoError(t, err)
+	graph := NewGraph(schedule)
+
+	now := time.Date(2023, 7, 23, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ret := now.Add(d)
+		return &ret
+	}
+	trips := []mta.TripUpdate{{
+		TripId:  "060000_1..S03R",
+		RouteId: "1",
+		StopTimeUpdate: []mta.StopTimeUpdate{
+			{StopID: "101S", Departure: at(2 * time.Minute)},
+			// predicted to get here before leaving 101S, and to leave before arriving
+			{StopID: "103S", Arrival: at(time.Minute), Departure: at(50 * time.Second)},
+			{StopID: "104S", Arrival: at(4 * time.Minute)},
+		},
+	}}
+
+	path, err := graph.FastestPath("101", "103", now, trips)
+	require.NoError(t, err)
+	assert.Equal(t, Path{
+		DepartAt: now,
+		ArriveAt: now.Add(2 * time.Minute),
+		Legs: []Leg{{
+			Type:     LegRide,
+			From:     "101S",
+			To:       "103S",
+			DepartAt: now.Add(2 * time.Minute),
+			ArriveAt: now.Add(2 * time.Minute),
+			TripID:   "060000_1..S03R",
+			RouteID:  "1",
+		}},
+	}, path)
+
+	// someone already at 103S can't catch the train before it has got there
+	path, err = graph.FastestPath("103S", "104", now.Add(90*time.Second), trips)
+	require.NoError(t, err)
+	require.Len(t, path.Legs, 1)
+	assert.Equal(t, now.Add(2*time.Minute), path.Legs[0].DepartAt)
+	assert.Equal(t, now.Add(4*time.Minute), path.ArriveAt)
+}
+
+func TestGraph_RunTime(t *testing.T) {
+	schedule, err := static.Load(fixtures)
+	require.NoError(t, err)
+	graph := NewGraph(schedule)
+
+	d, ok := graph.RunTime("101S", "103S")
+	require.True(t, ok)
+	assert.Equal(t, 90*time.Second, d)
+
+	_, ok = graph.RunTime("101S", "104S")
+	assert.False(t, ok)
+
+	start := time.Date(2023, 7, 23, 10, 0, 0, 0, time.UTC)
+	segment := func(d time.Duration) mta.Segment {
+		return mta.Segment{FromStation: "101S", ToStation: "103S", DepartAt: start, ArriveAt: start.Add(d)}
+	}
+	graph.ObserveSegments([]mta.Segment{segment(2 * time.Minute)})
+	d, _ = graph.RunTime("101S", "103S")
+	assert.Equal(t, 2*time.Minute, d)
+
+	// later observations are blended in rather than replacing what came before
+	graph.ObserveSegments([]mta.Segment{segment(6 * time.Minute)})
+	d, _ = graph.RunTime("101S", "103S")
+	assert.Equal(t, 3*time.Minute, d)
+}
@@ -0,0 +1,317 @@
+package mta
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// SegmentSink receives completed segments as StateProcessor produces them
+type SegmentSink interface {
+	Write(ctx context.Context, segments []Segment) error
+}
+
+// MultiSink fans segments out to multiple sinks. A failure in one sink does not prevent the others from receiving
+// segments, all failures are logged and returned joined together.
+type MultiSink struct {
+	sinks []SegmentSink
+}
+
+func NewMultiSink(sinks ...SegmentSink) *MultiSink {
+	return &MultiSink{
+		sinks: sinks,
+	}
+}
+
+func (m *MultiSink) Write(ctx context.Context, segments []Segment) error {
+	var errs []error
+	for _, s := range m.sinks {
+		err := s.Write(ctx, segments)
+		if err != nil {
+			sinkName := fmt.Sprintf("%T", s)
+			zerolog.Ctx(ctx).Err(err).Str("sink", sinkName).Msg("segment sink failed")
+			errs = append(errs, fmt.Errorf("%s: %w", sinkName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink that implements io.Closer, returning any failures joined together
+func (m *MultiSink) Close() error {
+	var errs []error
+	for _, s := range m.sinks {
+		if c, ok := s.(io.Closer); ok {
+			err := c.Close()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%T: %w", s, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// JSONLinesSink writes each segment as a single line of JSON
+type JSONLinesSink struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+func NewJSONLinesSink(out io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{
+		out: out,
+	}
+}
+
+// NewJSONLinesFileSink opens (or creates) the file at path for appending segments
+func NewJSONLinesFileSink(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{
+		out:    f,
+		closer: f,
+	}, nil
+}
+
+// NewStdoutSink writes segments as JSON lines to stdout
+func NewStdoutSink() *JSONLinesSink {
+	return NewJSONLinesSink(os.Stdout)
+}
+
+func (j *JSONLinesSink) Write(_ context.Context, segments []Segment) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	encoder := json.NewEncoder(j.out)
+	for _, s := range segments {
+		err := encoder.Encode(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JSONLinesSink) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
+
+var csvHeader = []string{
+	"trip_id",
+	"route_id",
+	"train_id",
+	"is_assigned",
+	"from_station",
+	"to_station",
+	"depart_at",
+	"arrive_at",
+	"scheduled_track",
+	"actual_track",
+	// the rest are only filled in for enriched segments, see WithSegmentEnricher
+	"from_name",
+	"from_borough",
+	"from_structure",
+	"to_name",
+	"to_borough",
+	"to_structure",
+	"route_short_name",
+	"route_color",
+	"distance_meters",
+}
+
+// CSVSink writes segments as CSV rows, times are formatted as RFC3339
+type CSVSink struct {
+	mutex         sync.Mutex
+	out           *csv.Writer
+	closer        io.Closer
+	headerPending bool
+}
+
+// NewCSVSink writes a header row before the first segment followed by a row per segment
+func NewCSVSink(out io.Writer) *CSVSink {
+	return &CSVSink{
+		out:           csv.NewWriter(out),
+		headerPending: true,
+	}
+}
+
+// NewCSVFileSink opens (or creates) the file at path for appending segments. The header row is only written if the
+// file is empty, a file with a different header (such as one written by an earlier version) is refused rather than
+// appended to with mismatched columns.
+func NewCSVFileSink(path string) (*CSVSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		header, err := csv.NewReader(f).Read()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading header of %s: %w", path, err)
+		}
+		if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+			f.Close()
+			return nil, fmt.Errorf("%s has different columns than segments are written with, use a new file", path)
+		}
+	}
+	return &CSVSink{
+		out:           csv.NewWriter(f),
+		closer:        f,
+		headerPending: info.Size() == 0,
+	}, nil
+}
+
+func (c *CSVSink) Write(_ context.Context, segments []Segment) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.headerPending {
+		err := c.out.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+		c.headerPending = false
+	}
+	for _, s := range segments {
+		err := c.out.Write(append([]string{
+			s.TripID,
+			s.RouteID,
+			s.TrainID,
+			strconv.FormatBool(s.IsAssigned),
+			s.FromStation,
+			s.ToStation,
+			s.DepartAt.Format(time.RFC3339),
+			s.ArriveAt.Format(time.RFC3339),
+			stringOrEmpty(s.ScheduledTrack),
+			stringOrEmpty(s.ActualTrack),
+		}, detailColumns(s.Details)...))
+		if err != nil {
+			return err
+		}
+	}
+	c.out.Flush()
+	return c.out.Error()
+}
+
+func (c *CSVSink) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func detailColumns(d *SegmentDetails) []string {
+	if d == nil {
+		return make([]string, 9)
+	}
+	distance := ""
+	if d.DistanceMeters > 0 {
+		distance = strconv.FormatFloat(d.DistanceMeters, 'f', 1, 64)
+	}
+	return []string{
+		d.From.Name,
+		d.From.Borough,
+		d.From.Structure,
+		d.To.Name,
+		d.To.Borough,
+		d.To.Structure,
+		d.Route.ShortName,
+		d.Route.Color,
+		distance,
+	}
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// SegmentFilter selects segments by route and station, an empty list matches everything
+type SegmentFilter struct {
+	Routes []string `json:"routes,omitempty"`
+	// Stations are matched against either end of a segment. Parent stop IDs (F27) match their directional platforms
+	// (F27N, F27S).
+	Stations []string `json:"stations,omitempty"`
+}
+
+func (f SegmentFilter) Matches(s Segment) bool {
+	if len(f.Routes) > 0 {
+		found := false
+		for _, r := range f.Routes {
+			if r == s.RouteID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Stations) > 0 {
+		found := false
+		for _, st := range f.Stations {
+			if SameStop(st, s.FromStation) || SameStop(st, s.ToStation) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FilteredSink only passes segments matching its filter on to the wrapped sink
+type FilteredSink struct {
+	sink   SegmentSink
+	filter SegmentFilter
+}
+
+func NewFilteredSink(sink SegmentSink, filter SegmentFilter) *FilteredSink {
+	return &FilteredSink{
+		sink:   sink,
+		filter: filter,
+	}
+}
+
+func (f *FilteredSink) Write(ctx context.Context, segments []Segment) error {
+	matched := make([]Segment, 0, len(segments))
+	for _, s := range segments {
+		if f.filter.Matches(s) {
+			matched = append(matched, s)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return f.sink.Write(ctx, matched)
+}
+
+// Close closes the wrapped sink if it implements io.Closer
+func (f *FilteredSink) Close() error {
+	if c, ok := f.sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package mta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSegments() []Segment {
+	track := "B2"
+	return []Segment{
+		{
+			FromStation:    "F27N",
+			ToStation:      "F26N",
+			DepartAt:       time.Date(2023, 7, 20, 18, 4, 11, 0, time.UTC),
+			ArriveAt:       time.Date(2023, 7, 20, 18, 6, 15, 0, time.UTC),
+			TripID:         "084421_G..N",
+			RouteID:        "G",
+			TrainID:        "1G 1404 CHU/CRS",
+			IsAssigned:     true,
+			ScheduledTrack: &track,
+			ActualTrack:    &track,
+		},
+		{
+			FromStation: "F26N",
+			ToStation:   "F25N",
+			DepartAt:    time.Date(2023, 7, 20, 18, 6, 15, 0, time.UTC),
+			ArriveAt:    time.Date(2023, 7, 20, 18, 8, 41, 0, time.UTC),
+			TripID:      "084421_G..N",
+			RouteID:     "G",
+			TrainID:     "1G 1404 CHU/CRS",
+			IsAssigned:  true,
+		},
+	}
+}
+
+func TestJSONLinesSink_Write(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := NewJSONLinesSink(out).Write(context.Background(), testSegments())
+	require.NoError(t, err)
+
+	expected := `{"fromStation":"F27N","toStation":"F26N","departAt":"2023-07-20T18:04:11Z","arriveAt":"2023-07-20T18:06:15Z","tripId":"084421_G..N","routeId":"G","trainId":"1G 1404 CHU/CRS","isAssigned":true,"scheduledTrack":"B2","actualTrack":"B2"}
+{"fromStation":"F26N","toStation":"F25N","departAt":"2023-07-20T18:06:15Z","arriveAt":"2023-07-20T18:08:41Z","tripId":"084421_G..N","routeId":"G","trainId":"1G 1404 CHU/CRS","isAssigned":true}
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestCSVFileSink_Write(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "segments.csv")
+
+	sink, err := NewCSVFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(ctx, testSegments()[:1]))
+	require.NoError(t, sink.Close())
+
+	// re-opening should append without repeating the header
+	sink, err = NewCSVFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(ctx, testSegments()[1:]))
+	require.NoError(t, sink.Close())
+
+	// enriched segments fill in the trailing columns
+	sink, err = NewCSVFileSink(path)
+	require.NoError(t, err)
+	enriched := testSegments()[1]
+	enriched.Details = &SegmentDetails{
+		From:           StationDetails{Name: "Bergen St", Borough: "Bk", Structure: "Subway"},
+		To:             StationDetails{Name: "Carroll St", Borough: "Bk", Structure: "Subway"},
+		Route:          RouteDetails{ShortName: "G", Color: "6CBE45"},
+		DistanceMeters: 612.34,
+	}
+	require.NoError(t, sink.Write(ctx, []Segment{enriched}))
+	require.NoError(t, sink.Close())
+
+	expected := `trip_id,route_id,train_id,is_assigned,from_station,to_station,depart_at,arrive_at,scheduled_track,actual_track,from_name,from_borough,from_structure,to_name,to_borough,to_structure,route_short_name,route_color,distance_meters
+084421_G..N,G,1G 1404 CHU/CRS,true,F27N,F26N,2023-07-20T18:04:11Z,2023-07-20T18:06:15Z,B2,B2,,,,,,,,,
+084421_G..N,G,1G 1404 CHU/CRS,true,F26N,F25N,2023-07-20T18:06:15Z,2023-07-20T18:08:41Z,,,,,,,,,,,
+084421_G..N,G,1G 1404 CHU/CRS,true,F26N,F25N,2023-07-20T18:06:15Z,2023-07-20T18:08:41Z,,,Bergen St,Bk,Subway,Carroll St,Bk,Subway,G,6CBE45,612.3
+`
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(b))
+}
+
+func TestCSVFileSink_MismatchedHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.csv")
+	require.NoError(t, os.WriteFile(path, []byte("trip_id,route_id\n084421_G..N,G\n"), 0644))
+
+	_, err := NewCSVFileSink(path)
+	assert.ErrorContains(t, err, "different columns")
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(context.Context, []Segment) error {
+	return errors.New("kaboom")
+}
+
+func TestMultiSink_Write(t *testing.T) {
+	first := new(bytes.Buffer)
+	second := new(bytes.Buffer)
+
+	err := NewMultiSink(NewJSONLinesSink(first), failingSink{}, NewJSONLinesSink(second)).Write(context.Background(), testSegments())
+	assert.ErrorContains(t, err, "kaboom")
+	// the failing sink must not prevent delivery to the others
+	assert.NotEmpty(t, first.String())
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestMultiSink_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.jsonl")
+	fileSink, err := NewJSONLinesFileSink(path)
+	require.NoError(t, err)
+
+	// sinks without a Close, like failingSink, are skipped
+	sink := NewFilteredSink(NewMultiSink(fileSink, failingSink{}), SegmentFilter{})
+	require.NoError(t, sink.Close())
+
+	// the underlying file should have been closed
+	assert.ErrorIs(t, fileSink.Close(), os.ErrClosed)
+}
+
+func TestFilteredSink_Write(t *testing.T) {
+	testCases := []struct {
+		name     string
+		filter   SegmentFilter
+		expected int
+	}{
+		{name: "no filter", filter: SegmentFilter{}, expected: 2},
+		{name: "route", filter: SegmentFilter{Routes: []string{"A", "G"}}, expected: 2},
+		{name: "other route", filter: SegmentFilter{Routes: []string{"A"}}, expected: 0},
+		{name: "parent station", filter: SegmentFilter{Stations: []string{"F25"}}, expected: 1},
+		{name: "platform", filter: SegmentFilter{Stations: []string{"F26N"}}, expected: 2},
+		{name: "route and station", filter: SegmentFilter{Routes: []string{"A"}, Stations: []string{"F26N"}}, expected: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			err := NewFilteredSink(NewJSONLinesSink(out), tc.filter).Write(context.Background(), testSegments())
+			require.NoError(t, err)
+			lines := 0
+			for _, b := range out.Bytes() {
+				if b == '\n' {
+					lines++
+				}
+			}
+			assert.Equal(t, tc.expected, lines)
+		})
+	}
+}
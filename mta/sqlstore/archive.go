@@ -0,0 +1,83 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/rs/zerolog"
+)
+
+// SegmentArchive records completed segments in the segments table. Segments are keyed on trip, stations and departure
+// time so writing the same segment twice is a no-op.
+type SegmentArchive struct {
+	db *sql.DB
+}
+
+func NewSegmentArchive(db *sql.DB) *SegmentArchive {
+	return &SegmentArchive{
+		db: db,
+	}
+}
+
+func (a *SegmentArchive) Write(ctx context.Context, segments []mta.Segment) error {
+	if len(segments) == 0 {
+		return nil
+	}
+	zerolog.Ctx(ctx).Debug().Int("segments", len(segments)).Msg("archiving segments")
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO segments (
+		trip_id, route_id, train_id, is_assigned, from_station, to_station, depart_at, arrive_at, scheduled_track, actual_track,
+		from_name, from_borough, from_structure, to_name, to_borough, to_structure, route_short_name, route_color, distance_meters
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, s := range segments {
+		args := []any{
+			s.TripID,
+			s.RouteID,
+			s.TrainID,
+			s.IsAssigned,
+			s.FromStation,
+			s.ToStation,
+			s.DepartAt.Unix(),
+			s.ArriveAt.Unix(),
+			s.ScheduledTrack,
+			s.ActualTrack,
+		}
+		_, err = stmt.ExecContext(ctx, append(args, detailArgs(s.Details)...)...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// detailArgs are the values of the detail columns, all NULL for segments that haven't been enriched
+func detailArgs(d *mta.SegmentDetails) []any {
+	if d == nil {
+		return make([]any, 9)
+	}
+	var distance *float64
+	if d.DistanceMeters > 0 {
+		distance = &d.DistanceMeters
+	}
+	return []any{
+		d.From.Name,
+		d.From.Borough,
+		d.From.Structure,
+		d.To.Name,
+		d.To.Borough,
+		d.To.Structure,
+		d.Route.ShortName,
+		d.Route.Color,
+		distance,
+	}
+}
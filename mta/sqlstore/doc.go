@@ -0,0 +1,5 @@
+package sqlstore
+
+// SQL backed persistence for the mta package: a StateStore for in-flight trips and an archive of completed segments.
+// Everything is written against database/sql, the sqlite driver (modernc.org/sqlite, no cgo required) is registered
+// so a local file can be used via Open.
@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	_ "modernc.org/sqlite"
+)
+
+var schema = []string{
+	// keyed on position rather than trip ID, feeds occasionally repeat a trip and the other stores keep whatever they
+	// are given
+	`CREATE TABLE IF NOT EXISTS trip_state (
+		position INTEGER PRIMARY KEY,
+		trip_id TEXT NOT NULL,
+		state TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS segments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		trip_id TEXT NOT NULL,
+		route_id TEXT NOT NULL,
+		train_id TEXT NOT NULL,
+		is_assigned BOOLEAN NOT NULL,
+		from_station TEXT NOT NULL,
+		to_station TEXT NOT NULL,
+		depart_at INTEGER NOT NULL,
+		arrive_at INTEGER NOT NULL,
+		scheduled_track TEXT,
+		actual_track TEXT,
+		from_name TEXT,
+		from_borough TEXT,
+		from_structure TEXT,
+		to_name TEXT,
+		to_borough TEXT,
+		to_structure TEXT,
+		route_short_name TEXT,
+		route_color TEXT,
+		distance_meters REAL,
+		UNIQUE (trip_id, from_station, to_station, depart_at)
+	)`,
+	`CREATE INDEX IF NOT EXISTS segments_route_id ON segments (route_id)`,
+	`CREATE INDEX IF NOT EXISTS segments_from_to ON segments (from_station, to_station)`,
+	`CREATE INDEX IF NOT EXISTS segments_depart_at ON segments (depart_at)`,
+}
+
+// Open opens (creating if needed) the sqlite database at path and ensures the schema exists
+func Open(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports a single writer, serialize access rather than fighting over locks
+	db.SetMaxOpenConns(1)
+	err = Migrate(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrate creates any tables and indexes that do not exist yet
+func Migrate(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range schema {
+		_, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := Open(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func TestStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	arrival := time.Unix(1689876251, 0)
+	direction := mta.DirectionNorth
+	state := []mta.TripUpdate{
+		{
+			TripId:     "084421_G..N",
+			RouteId:    "G",
+			TrainId:    "1G 1404 CHU/CRS",
+			IsAssigned: true,
+			Direction:  &direction,
+			StopTimeUpdate: []mta.StopTimeUpdate{
+				{
+					StopID:     "F27N",
+					Arrival:    &arrival,
+					Departure:  &arrival,
+					IsComplete: true,
+				},
+			},
+		},
+		{
+			TripId:  "084500_G..S",
+			RouteId: "G",
+		},
+	}
+
+	prior, err := NewStore(db).PriorState(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, prior)
+
+	require.NoError(t, NewStore(db).RecordState(ctx, state))
+	reloaded, err := NewStore(db).PriorState(ctx)
+	require.NoError(t, err)
+	require.Len(t, reloaded, 2)
+	assert.Equal(t, "084421_G..N", reloaded[0].TripId)
+	assert.Equal(t, "084500_G..S", reloaded[1].TripId)
+	assert.True(t, reloaded[0].StopTimeUpdate[0].IsComplete)
+
+	// recording again fully replaces what was there
+	require.NoError(t, NewStore(db).RecordState(ctx, state[1:]))
+	reloaded, err = NewStore(db).PriorState(ctx)
+	require.NoError(t, err)
+	require.Len(t, reloaded, 1)
+	assert.Equal(t, "084500_G..S", reloaded[0].TripId)
+}
+
+func TestStore_DuplicateTrips(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// the memory and file stores keep duplicates as given, so this one must too
+	state := []mta.TripUpdate{
+		{TripId: "084421_G..N", RouteId: "G"},
+		{TripId: "084421_G..N", RouteId: "G", TrainId: "1G 1404 CHU/CRS"},
+	}
+	require.NoError(t, NewStore(db).RecordState(ctx, state))
+	reloaded, err := NewStore(db).PriorState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, state, reloaded)
+}
+
+func TestSegmentArchive_Write(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	track := "B2"
+	segments := []mta.Segment{
+		{
+			FromStation:    "F27N",
+			ToStation:      "F26N",
+			DepartAt:       time.Unix(1689876251, 0),
+			ArriveAt:       time.Unix(1689876375, 0),
+			TripID:         "084421_G..N",
+			RouteID:        "G",
+			TrainID:        "1G 1404 CHU/CRS",
+			IsAssigned:     true,
+			ScheduledTrack: &track,
+			ActualTrack:    &track,
+		},
+		{
+			FromStation: "F26N",
+			ToStation:   "F25N",
+			DepartAt:    time.Unix(1689876375, 0),
+			ArriveAt:    time.Unix(1689876521, 0),
+			TripID:      "084421_G..N",
+			RouteID:     "G",
+			TrainID:     "1G 1404 CHU/CRS",
+			IsAssigned:  true,
+		},
+	}
+
+	archive := NewSegmentArchive(db)
+	require.NoError(t, archive.Write(ctx, segments))
+	// duplicates are ignored
+	require.NoError(t, archive.Write(ctx, segments[:1]))
+
+	var count int
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM segments WHERE route_id = ?`, "G").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	var from, to string
+	var departAt, arriveAt int64
+	var actualTrack sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT from_station, to_station, depart_at, arrive_at, actual_track FROM segments WHERE from_station = ? AND to_station = ?`, "F26N", "F25N").
+		Scan(&from, &to, &departAt, &arriveAt, &actualTrack)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1689876375), departAt)
+	assert.Equal(t, int64(1689876521), arriveAt)
+	assert.False(t, actualTrack.Valid)
+}
+
+func TestSegmentArchive_Write_Details(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	segment := mta.Segment{
+		FromStation: "F27N",
+		ToStation:   "F26N",
+		DepartAt:    time.Unix(1689876251, 0),
+		ArriveAt:    time.Unix(1689876375, 0),
+		TripID:      "084421_G..N",
+		RouteID:     "G",
+		Details: &mta.SegmentDetails{
+			From:           mta.StationDetails{Name: "Church Av", Borough: "Bk", Structure: "Subway"},
+			To:             mta.StationDetails{Name: "Fort Hamilton Pkwy", Borough: "Bk", Structure: "Subway"},
+			Route:          mta.RouteDetails{ShortName: "G", Color: "6CBE45"},
+			DistanceMeters: 812.5,
+		},
+	}
+	require.NoError(t, NewSegmentArchive(db).Write(ctx, []mta.Segment{segment}))
+
+	var fromName, toBorough, routeColor string
+	var distance float64
+	err := db.QueryRowContext(ctx, `SELECT from_name, to_borough, route_color, distance_meters FROM segments`).
+		Scan(&fromName, &toBorough, &routeColor, &distance)
+	require.NoError(t, err)
+	assert.Equal(t, "Church Av", fromName)
+	assert.Equal(t, "Bk", toBorough)
+	assert.Equal(t, "6CBE45", routeColor)
+	assert.Equal(t, 812.5, distance)
+}
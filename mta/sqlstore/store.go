@@ -0,0 +1,74 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jonsabados/mta2furious/mta"
+	"github.com/rs/zerolog"
+)
+
+// Store is a mta.StateStore that keeps in-flight trips in the trip_state table
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) PriorState(ctx context.Context) ([]mta.TripUpdate, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT state FROM trip_state ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make([]mta.TripUpdate, 0)
+	for rows.Next() {
+		var raw string
+		err = rows.Scan(&raw)
+		if err != nil {
+			return nil, err
+		}
+		var trip mta.TripUpdate
+		err = json.Unmarshal([]byte(raw), &trip)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, trip)
+	}
+	return ret, rows.Err()
+}
+
+func (s *Store) RecordState(ctx context.Context, state []mta.TripUpdate) error {
+	zerolog.Ctx(ctx).Debug().Int("trips", len(state)).Msg("persisting state to database")
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trip_state`)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO trip_state (position, trip_id, state) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, trip := range state {
+		raw, err := json.Marshal(trip)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, i, trip.TripId, string(raw))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
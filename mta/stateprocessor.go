@@ -2,6 +2,7 @@ package mta
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -9,7 +10,7 @@ import (
 
 //go:generate mockery --name=StateOracle
 type StateOracle interface {
-	CurrentState(ctx context.Context) ([]TripUpdate, error)
+	CurrentState(ctx context.Context) (SystemState, error)
 }
 
 type StateStore interface {
@@ -17,82 +18,187 @@ type StateStore interface {
 	RecordState(ctx context.Context, state []TripUpdate) error
 }
 
+// StoreError is returned by ProcessUpdates when the StateStore failed, as opposed to the feeds
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("failed to %s state: %v", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
 type Segment struct {
-	FromStation    string
-	ToStation      string
-	DepartAt       time.Time
-	ArriveAt       time.Time
-	TripID         string
-	RouteID        string
-	TrainID        string
-	IsAssigned     bool
-	ScheduledTrack *string
-	ActualTrack    *string
+	FromStation    string    `json:"fromStation"`
+	ToStation      string    `json:"toStation"`
+	DepartAt       time.Time `json:"departAt"`
+	ArriveAt       time.Time `json:"arriveAt"`
+	TripID         string    `json:"tripId"`
+	RouteID        string    `json:"routeId"`
+	TrainID        string    `json:"trainId"`
+	IsAssigned     bool      `json:"isAssigned"`
+	ScheduledTrack *string   `json:"scheduledTrack,omitempty"`
+	ActualTrack    *string   `json:"actualTrack,omitempty"`
+	// Details is static metadata about the segment, only present if the processor was given an enricher
+	Details *SegmentDetails `json:"details,omitempty"`
 }
 
 type StateUpdateResults struct {
 	CompletedSegments []Segment
+	// CompletedTrips summarize each trip that ran its last segment
+	CompletedTrips []CompletedTrip
+	// StopVisits are the stops trains have finished with, they are produced when the train is known to have left
+	StopVisits []StopVisit
+	// Trips is the in-flight state recorded at the end of processing
+	Trips []TripUpdate
+	// TripEvents are the lifecycle changes observed while processing, in the order they were observed
+	TripEvents []TripEvent
+	// Feeds reports on each feed pulled to produce the results
+	Feeds []FeedReport
 }
 
 type StateProcessor struct {
-	oracle StateOracle
-	store  StateStore
-	now    func() time.Time
+	oracle               StateOracle
+	store                StateStore
+	now                  func() time.Time
+	useStoppedAtArrivals bool
+	enricher             SegmentEnricher
+	// lastState is the state most recently recorded by ProcessUpdates, nil until a run succeeds
+	lastState []TripUpdate
+}
+
+type StateProcessorOption func(p *StateProcessor)
+
+// WithStoppedAtArrivals causes vehicle positions reporting a train as STOPPED_AT a stop to be used as the actual arrival
+// time at that stop, and to mark any earlier stops the train has passed as complete, rather than relying solely on
+// stops dropping off the feed.
+func WithStoppedAtArrivals() StateProcessorOption {
+	return func(p *StateProcessor) {
+		p.useStoppedAtArrivals = true
+	}
 }
 
-func NewStateProcessor(oracle StateOracle, store StateStore) *StateProcessor {
-	return &StateProcessor{
+// WithSegmentEnricher decorates completed segments with static station and route metadata before they are returned
+func WithSegmentEnricher(enricher SegmentEnricher) StateProcessorOption {
+	return func(p *StateProcessor) {
+		p.enricher = enricher
+	}
+}
+
+func NewStateProcessor(oracle StateOracle, store StateStore, opts ...StateProcessorOption) *StateProcessor {
+	ret := &StateProcessor{
 		oracle: oracle,
 		store:  store,
 		now:    time.Now,
 	}
+	for _, o := range opts {
+		o(ret)
+	}
+	return ret
 }
 
 func (p *StateProcessor) ProcessUpdates(ctx context.Context) (StateUpdateResults, error) {
 	zerolog.Ctx(ctx).Debug().Msg("processing updates")
 	priorState, err := p.store.PriorState(ctx)
 	if err != nil {
-		return StateUpdateResults{}, err
+		return StateUpdateResults{}, &StoreError{Op: "load", Err: err}
 	}
-	currentState, err := p.oracle.CurrentState(ctx)
+	systemState, err := p.oracle.CurrentState(ctx)
 	if err != nil {
 		return StateUpdateResults{}, err
 	}
+	currentState := systemState.TripUpdates
+	unreliableFeeds := systemState.unreliableFeeds()
 	newState := make([]TripUpdate, 0)
 	completedSegments := make([]Segment, 0)
+	tripEvents := make([]TripEvent, 0)
+	completedTrips := make([]CompletedTrip, 0)
+	stopVisits := make([]StopVisit, 0)
 
 	// first update the state of things
 	for _, prior := range priorState {
-		newVersion, segmentsDone := p.processTrip(ctx, prior, currentState)
-		if len(segmentsDone) > 0 {
-			completedSegments = append(completedSegments, segmentsDone...)
+		// what a stale or failed feed says (or doesn't) can't be trusted, so leave its trips exactly as they were
+		if unreliableFeeds[prior.Feed] {
+			newState = append(newState, prior)
+			continue
+		}
+		progress := p.processTrip(ctx, prior, currentState)
+		if len(progress.segments) > 0 {
+			completedSegments = append(completedSegments, progress.segments...)
 		}
-		if newVersion != nil {
-			newState = append(newState, *newVersion)
+		stopVisits = append(stopVisits, progress.visits...)
+		tripEvents = append(tripEvents, progress.events...)
+		if progress.completedTrip != nil {
+			completedTrips = append(completedTrips, *progress.completedTrip)
+		}
+		if progress.trip != nil {
+			newState = append(newState, *progress.trip)
 		}
 	}
 
 	// add any trips we haven't seen before to our new durable state
 	for _, current := range currentState {
-		if locateTrip(current.TripId, priorState) == nil {
+		if locateTrip(current.TripId, priorState) == nil && !unreliableFeeds[current.Feed] {
 			zerolog.Ctx(ctx).Debug().Interface("trip", current).Msg("new trip found")
 			newState = append(newState, current)
+			tripEvents = append(tripEvents, p.tripEvent(TripStarted, current))
 		}
 	}
 
 	err = p.store.RecordState(ctx, newState)
 	if err != nil {
-		return StateUpdateResults{}, err
+		return StateUpdateResults{}, &StoreError{Op: "record", Err: err}
+	}
+	p.lastState = newState
+
+	if p.enricher != nil {
+		for i, s := range completedSegments {
+			completedSegments[i] = p.enricher.Enrich(s)
+		}
 	}
 
 	return StateUpdateResults{
 		CompletedSegments: completedSegments,
+		CompletedTrips:    completedTrips,
+		StopVisits:        stopVisits,
+		Trips:             newState,
+		TripEvents:        tripEvents,
+		Feeds:             systemState.Feeds,
 	}, nil
 }
 
-// processTrip looks for updates to the trip, and returns the new version and completed segments. If the trip has been completed entirely nil is returned
-func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, currentState []TripUpdate) (*TripUpdate, []Segment) {
+// Flush records the state from the most recent successful ProcessUpdates again, for use when shutting down so the store
+// is known to be left holding the state matching the last results handed out. It does nothing if no run has succeeded.
+// It must not be called concurrently with ProcessUpdates.
+func (p *StateProcessor) Flush(ctx context.Context) error {
+	if p.lastState == nil {
+		return nil
+	}
+	err := p.store.RecordState(ctx, p.lastState)
+	if err != nil {
+		return &StoreError{Op: "record", Err: err}
+	}
+	return nil
+}
+
+// tripProgress is what processing a single trip produced
+type tripProgress struct {
+	// trip is the new version of the trip, nil if it has been completed entirely or discarded
+	trip          *TripUpdate
+	segments      []Segment
+	visits        []StopVisit
+	events        []TripEvent
+	completedTrip *CompletedTrip
+}
+
+// processTrip looks for updates to the trip, and returns the new version, completed segments and any lifecycle events
+func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, currentState []TripUpdate) tripProgress {
 	var rawUpdates []StopTimeUpdate
+	var events []TripEvent
 	rawState := locateTrip(trip.TripId, currentState)
 
 	if rawState == nil {
@@ -113,7 +219,16 @@ func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, curre
 				// note - were intentionally not returning, rawUpdates will be left nil which will cause the remaining stop to be picked up as completed later
 			} else {
 				zerolog.Ctx(ctx).Info().Interface("trip", trip).Msg("trip with completed stops fell off the radar")
-				return &trip, nil
+				// only the first pull missing the trip is an event, later ones are more of the same
+				if trip.VanishedAt != nil {
+					return tripProgress{trip: &trip}
+				}
+				vanishedAt := p.now()
+				trip.VanishedAt = &vanishedAt
+				return tripProgress{
+					trip:   &trip,
+					events: []TripEvent{p.tripEvent(TripVanished, trip)},
+				}
 			}
 		} else {
 			if hasCompletedStops {
@@ -122,10 +237,16 @@ func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, curre
 			} else {
 				zerolog.Ctx(ctx).Debug().Msg("trip with no completed stops fell of the radar and discarding")
 			}
-			return nil, nil
+			return tripProgress{
+				events: []TripEvent{p.tripEvent(TripDiscarded, trip)},
+			}
 		}
 	} else {
 		rawUpdates = rawState.StopTimeUpdate
+		if trip.VanishedAt != nil {
+			zerolog.Ctx(ctx).Info().Str("tripID", trip.TripId).Time("vanishedAt", *trip.VanishedAt).Msg("trip reappeared")
+			events = append(events, p.tripEvent(TripReappeared, trip))
+		}
 	}
 
 	updates := make([]StopTimeUpdate, 0)
@@ -139,16 +260,30 @@ func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, curre
 		// if the new version is gone then the stop is complete (mta signals completion by dropping it...)
 		if newVersion == nil {
 			stop.IsComplete = true
+			// ...unless the whole trip is gone, in which case we're only assuming it
+			stop.Inferred = rawState == nil
 			updates = append(updates, stop)
 			continue
 		}
+		// an observed arrival beats any prediction the feed is still carrying
+		if stop.ArrivalObserved {
+			newVersion.Arrival = stop.Arrival
+			newVersion.ArrivalObserved = true
+		}
 		// finally drop the updated version in place
 		updates = append(updates, *newVersion)
 	}
 
+	if p.useStoppedAtArrivals && rawState != nil {
+		applyStoppedAt(ctx, rawState.Vehicle, updates)
+	}
+
 	// next lets find completed segments in our updates, and build the new list of pending items
 	stillPending := make([]StopTimeUpdate, 0)
 	completed := make([]Segment, 0)
+	history := make([]StopTimeUpdate, len(trip.History))
+	copy(history, trip.History)
+	visits := make([]StopVisit, 0)
 	for i := 0; i < len(updates); i++ {
 		leg := updates[i]
 		if !leg.IsComplete {
@@ -157,6 +292,8 @@ func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, curre
 		}
 		// if we are complete and were the tail item were done
 		if i == len(updates)-1 {
+			history = append(history, leg)
+			visits = append(visits, newStopVisit(trip, leg))
 			break
 		}
 		nextLeg := updates[i+1]
@@ -164,33 +301,101 @@ func (p *StateProcessor) processTrip(ctx context.Context, trip TripUpdate, curre
 			// if our next leg isn't complete we need to retain the completed leg
 			stillPending = append(stillPending, leg)
 		} else {
-			completed = append(completed, Segment{
-				FromStation:    leg.StopID,
-				ToStation:      nextLeg.StopID,
-				DepartAt:       *leg.Departure,
-				ArriveAt:       *nextLeg.Arrival,
-				TripID:         trip.TripId,
-				RouteID:        trip.RouteId,
-				TrainID:        trip.TrainId,
-				IsAssigned:     trip.IsAssigned,
-				ScheduledTrack: leg.ScheduledTrack,
-				ActualTrack:    leg.ActualTrack,
-			})
+			// stops may carry only one of their times, or (from a malformed entity) neither, in which case there is no
+			// segment to be had but the stop is still done with
+			departAt := firstTime(leg.Departure, leg.Arrival)
+			arriveAt := firstTime(nextLeg.Arrival, nextLeg.Departure)
+			if departAt == nil || arriveAt == nil {
+				zerolog.Ctx(ctx).Warn().Str("tripID", trip.TripId).Str("from", leg.StopID).Str("to", nextLeg.StopID).Msg("stop without times, skipping segment")
+			} else {
+				completed = append(completed, Segment{
+					FromStation:    leg.StopID,
+					ToStation:      nextLeg.StopID,
+					DepartAt:       *departAt,
+					ArriveAt:       *arriveAt,
+					TripID:         trip.TripId,
+					RouteID:        trip.RouteId,
+					TrainID:        trip.TrainId,
+					IsAssigned:     trip.IsAssigned,
+					ScheduledTrack: leg.ScheduledTrack,
+					ActualTrack:    leg.ActualTrack,
+				})
+			}
+			history = append(history, leg)
+			visits = append(visits, newStopVisit(trip, leg))
 		}
 	}
 
 	if len(stillPending) > 0 {
-		return &TripUpdate{
-			TripId:         rawState.TripId,
-			RouteId:        rawState.RouteId,
-			TrainId:        rawState.TrainId,
-			IsAssigned:     rawState.IsAssigned,
-			Direction:      rawState.Direction,
-			StopTimeUpdate: stillPending,
-		}, completed
+		return tripProgress{
+			trip: &TripUpdate{
+				TripId:         rawState.TripId,
+				RouteId:        rawState.RouteId,
+				TrainId:        rawState.TrainId,
+				IsAssigned:     rawState.IsAssigned,
+				Direction:      rawState.Direction,
+				StopTimeUpdate: stillPending,
+				Feed:           rawState.Feed,
+				Vehicle:        rawState.Vehicle,
+				History:        history,
+			},
+			segments: completed,
+			visits:   visits,
+			events:   events,
+		}
 	}
 	zerolog.Ctx(ctx).Info().Str("tripID", trip.TripId).Msg("trip complete")
-	return nil, completed
+	return tripProgress{
+		segments:      completed,
+		visits:        visits,
+		events:        append(events, p.tripEvent(TripCompleted, trip)),
+		completedTrip: newCompletedTrip(trip, history),
+	}
+}
+
+func (p *StateProcessor) tripEvent(eventType TripEventType, trip TripUpdate) TripEvent {
+	return TripEvent{
+		Type:    eventType,
+		TripID:  trip.TripId,
+		RouteID: trip.RouteId,
+		Feed:    trip.Feed,
+		At:      p.now(),
+	}
+}
+
+// applyStoppedAt records the vehicle's timestamp as the actual arrival when it is reported as STOPPED_AT one of the
+// trips pending stops. Any stops before that one have been passed and are marked complete.
+func applyStoppedAt(ctx context.Context, vehicle *VehiclePosition, updates []StopTimeUpdate) {
+	if vehicle == nil || vehicle.CurrentStatus != VehicleStoppedAt || vehicle.Timestamp == nil {
+		return
+	}
+	stopIdx := -1
+	for i, u := range updates {
+		if u.StopID == vehicle.StopID {
+			stopIdx = i
+			break
+		}
+	}
+	if stopIdx < 0 {
+		return
+	}
+	for i := 0; i < stopIdx; i++ {
+		if !updates[i].IsComplete {
+			zerolog.Ctx(ctx).Debug().Str("tripID", vehicle.TripId).Str("stopID", updates[i].StopID).Msg("vehicle stopped past stop, marking complete")
+			updates[i].IsComplete = true
+			updates[i].Inferred = true
+		}
+	}
+	stop := &updates[stopIdx]
+	if stop.IsComplete || stop.ArrivalObserved {
+		return
+	}
+	arrival := *vehicle.Timestamp
+	stop.Arrival = &arrival
+	stop.ArrivalObserved = true
+	if stop.Departure != nil && stop.Departure.Before(arrival) {
+		stop.Departure = &arrival
+	}
 }
 
 func locateStop(stopID string, updates []StopTimeUpdate) *StopTimeUpdate {
@@ -2,6 +2,7 @@ package mta
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -172,7 +173,7 @@ func TestStateProcessor_ProcessUpdates(t *testing.T) {
 
 			for _, it := range tc.iterations {
 				testTime = it.time
-				oracle.EXPECT().CurrentState(ctx).Return(it.systemStatus, nil).Times(1)
+				oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: it.systemStatus}, nil).Times(1)
 				completed, err := testInstance.ProcessUpdates(ctx)
 				require.NoError(t, err)
 				segmentsGot = append(segmentsGot, completed.CompletedSegments...)
@@ -182,3 +183,710 @@ func TestStateProcessor_ProcessUpdates(t *testing.T) {
 		})
 	}
 }
+
+func TestStateProcessor_ProcessUpdates_StoppedAtArrivals(t *testing.T) {
+	ctx := context.Background()
+
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+
+	trip := func(vehicle *VehiclePosition, stops ...StopTimeUpdate) TripUpdate {
+		return TripUpdate{
+			TripId:         "084421_G..N",
+			RouteId:        "G",
+			TrainId:        "1G 1404 CHU/CRS",
+			IsAssigned:     true,
+			StopTimeUpdate: stops,
+			Vehicle:        vehicle,
+		}
+	}
+	stop := func(stopID string, at string) StopTimeUpdate {
+		return StopTimeUpdate{
+			StopID:    stopID,
+			Arrival:   timeOrDie(at),
+			Departure: timeOrDie(at),
+		}
+	}
+	vehicle := func(status VehicleStopStatus, stopID string, at string) *VehiclePosition {
+		return &VehiclePosition{
+			TripId:        "084421_G..N",
+			RouteId:       "G",
+			StopID:        stopID,
+			CurrentStatus: status,
+			Timestamp:     timeOrDie(at),
+		}
+	}
+
+	iterations := []struct {
+		time         time.Time
+		systemStatus []TripUpdate
+	}{
+		{
+			time: *timeOrDie("2023-07-20T14:04:00-04:00"),
+			systemStatus: []TripUpdate{
+				trip(vehicle(VehicleIncomingAt, "F27N", "2023-07-20T14:04:00-04:00"),
+					stop("F27N", "2023-07-20T14:04:11-04:00"),
+					stop("F26N", "2023-07-20T14:06:11-04:00"),
+					stop("F25N", "2023-07-20T14:08:41-04:00"),
+				),
+			},
+		},
+		{
+			// the feed still lists F27N, but the train is stopped at F26N so it must have been passed
+			time: *timeOrDie("2023-07-20T14:06:05-04:00"),
+			systemStatus: []TripUpdate{
+				trip(vehicle(VehicleStoppedAt, "F26N", "2023-07-20T14:06:05-04:00"),
+					stop("F27N", "2023-07-20T14:04:11-04:00"),
+					stop("F26N", "2023-07-20T14:06:15-04:00"),
+					stop("F25N", "2023-07-20T14:08:41-04:00"),
+				),
+			},
+		},
+		{
+			time: *timeOrDie("2023-07-20T14:06:30-04:00"),
+			systemStatus: []TripUpdate{
+				trip(vehicle(VehicleInTransitTo, "F25N", "2023-07-20T14:06:30-04:00"),
+					stop("F25N", "2023-07-20T14:08:41-04:00"),
+				),
+			},
+		},
+		{
+			time:         *timeOrDie("2023-07-20T14:10:16-04:00"),
+			systemStatus: []TripUpdate{trip(nil)},
+		},
+	}
+
+	oracle := NewMockStateOracle(t)
+	var testTime time.Time
+	testInstance := NewStateProcessor(oracle, NewMemoryStore(), WithStoppedAtArrivals())
+	testInstance.now = func() time.Time {
+		return testTime
+	}
+
+	segmentsGot := make([]Segment, 0)
+	for _, it := range iterations {
+		testTime = it.time
+		oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: it.systemStatus}, nil).Times(1)
+		completed, err := testInstance.ProcessUpdates(ctx)
+		require.NoError(t, err)
+		segmentsGot = append(segmentsGot, completed.CompletedSegments...)
+	}
+
+	expected := []Segment{
+		{
+			FromStation: "F27N",
+			ToStation:   "F26N",
+			DepartAt:    *timeOrDie("2023-07-20T14:04:11-04:00"),
+			ArriveAt:    *timeOrDie("2023-07-20T14:06:05-04:00"),
+			TripID:      "084421_G..N",
+			RouteID:     "G",
+			TrainID:     "1G 1404 CHU/CRS",
+			IsAssigned:  true,
+		},
+		{
+			FromStation: "F26N",
+			ToStation:   "F25N",
+			DepartAt:    *timeOrDie("2023-07-20T14:06:15-04:00"),
+			ArriveAt:    *timeOrDie("2023-07-20T14:08:41-04:00"),
+			TripID:      "084421_G..N",
+			RouteID:     "G",
+			TrainID:     "1G 1404 CHU/CRS",
+			IsAssigned:  true,
+		},
+	}
+	assert.Equal(t, expected, segmentsGot)
+}
+
+func TestStateProcessor_ProcessUpdates_UnreliableFeeds(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+
+	gTrip := TripUpdate{
+		TripId:     "084421_G..N",
+		RouteId:    "G",
+		TrainId:    "1G 1404 CHU/CRS",
+		IsAssigned: true,
+		Feed:       "g",
+		StopTimeUpdate: []StopTimeUpdate{
+			{StopID: "F27N", Arrival: timeOrDie("2023-07-20T14:04:11-04:00"), Departure: timeOrDie("2023-07-20T14:04:11-04:00")},
+			{StopID: "F26N", Arrival: timeOrDie("2023-07-20T14:06:11-04:00"), Departure: timeOrDie("2023-07-20T14:06:11-04:00")},
+		},
+	}
+	lTrip := TripUpdate{
+		TripId:     "084500_L..S",
+		RouteId:    "L",
+		IsAssigned: true,
+		Feed:       "l",
+		StopTimeUpdate: []StopTimeUpdate{
+			{StopID: "L08S", Arrival: timeOrDie("2023-07-20T14:05:00-04:00"), Departure: timeOrDie("2023-07-20T14:05:00-04:00")},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		secondPull     SystemState
+		expectedState  []TripUpdate
+		expectedEvents []TripEvent
+	}{
+		{
+			// the g feed froze with a snapshot missing the trip, and the stale l feed has a trip we haven't seen
+			name: "stale feeds",
+			secondPull: SystemState{
+				TripUpdates: []TripUpdate{lTrip},
+				Feeds:       []FeedReport{{Name: "g", Stale: true}, {Name: "l", Stale: true}},
+			},
+			expectedState:  []TripUpdate{gTrip},
+			expectedEvents: []TripEvent{},
+		},
+		{
+			name: "failed feed",
+			secondPull: SystemState{
+				TripUpdates: []TripUpdate{lTrip},
+				Feeds:       []FeedReport{{Name: "g", Err: errors.New("503")}, {Name: "l"}},
+			},
+			expectedState: []TripUpdate{gTrip, lTrip},
+			expectedEvents: []TripEvent{
+				{Type: TripStarted, TripID: "084500_L..S", RouteID: "L", Feed: "l", At: *timeOrDie("2023-07-20T14:05:00-04:00")},
+			},
+		},
+		{
+			name: "healthy feeds",
+			secondPull: SystemState{
+				TripUpdates: []TripUpdate{lTrip},
+				Feeds:       []FeedReport{{Name: "g"}, {Name: "l"}},
+			},
+			expectedState: []TripUpdate{lTrip},
+			expectedEvents: []TripEvent{
+				{Type: TripDiscarded, TripID: "084421_G..N", RouteID: "G", Feed: "g", At: *timeOrDie("2023-07-20T14:05:00-04:00")},
+				{Type: TripStarted, TripID: "084500_L..S", RouteID: "L", Feed: "l", At: *timeOrDie("2023-07-20T14:05:00-04:00")},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			oracle := NewMockStateOracle(t)
+			store := NewMemoryStore()
+			testInstance := NewStateProcessor(oracle, store)
+			testInstance.now = func() time.Time {
+				return *timeOrDie("2023-07-20T14:05:00-04:00")
+			}
+
+			oracle.EXPECT().CurrentState(ctx).Return(SystemState{
+				TripUpdates: []TripUpdate{gTrip},
+				Feeds:       []FeedReport{{Name: "g"}, {Name: "l"}},
+			}, nil).Once()
+			_, err := testInstance.ProcessUpdates(ctx)
+			require.NoError(t, err)
+
+			oracle.EXPECT().CurrentState(ctx).Return(tc.secondPull, nil).Once()
+			res, err := testInstance.ProcessUpdates(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, res.CompletedSegments)
+			assert.Equal(t, tc.secondPull.Feeds, res.Feeds)
+			assert.Equal(t, tc.expectedEvents, res.TripEvents)
+			assert.Equal(t, tc.expectedState, res.Trips)
+
+			state, err := store.PriorState(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedState, state)
+		})
+	}
+}
+
+func TestStateProcessor_ProcessUpdates_TripEvents(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+	stops := []StopTimeUpdate{
+		{StopID: "F27N", Arrival: timeOrDie("2023-07-20T14:04:11-04:00"), Departure: timeOrDie("2023-07-20T14:04:11-04:00")},
+		{StopID: "F26N", Arrival: timeOrDie("2023-07-20T14:06:11-04:00"), Departure: timeOrDie("2023-07-20T14:06:11-04:00")},
+		{StopID: "F25N", Arrival: timeOrDie("2023-07-20T14:08:11-04:00"), Departure: timeOrDie("2023-07-20T14:08:11-04:00")},
+	}
+	trip := func(stops ...StopTimeUpdate) TripUpdate {
+		return TripUpdate{TripId: "084421_G..N", RouteId: "G", IsAssigned: true, Feed: "g", StopTimeUpdate: stops}
+	}
+	event := func(eventType TripEventType, at string) TripEvent {
+		return TripEvent{Type: eventType, TripID: "084421_G..N", RouteID: "G", Feed: "g", At: *timeOrDie(at)}
+	}
+
+	pulls := []struct {
+		at       string
+		trips    []TripUpdate
+		expected []TripEvent
+	}{
+		{at: "2023-07-20T14:04:00-04:00", trips: []TripUpdate{trip(stops...)}, expected: []TripEvent{event(TripStarted, "2023-07-20T14:04:00-04:00")}},
+		{at: "2023-07-20T14:05:00-04:00", trips: []TripUpdate{trip(stops[1:]...)}, expected: []TripEvent{}},
+		// missing from two pulls in a row is only a single vanishing
+		{at: "2023-07-20T14:06:00-04:00", expected: []TripEvent{event(TripVanished, "2023-07-20T14:06:00-04:00")}},
+		{at: "2023-07-20T14:06:30-04:00", expected: []TripEvent{}},
+		{at: "2023-07-20T14:07:00-04:00", trips: []TripUpdate{trip(stops[2:]...)}, expected: []TripEvent{event(TripReappeared, "2023-07-20T14:07:00-04:00")}},
+		{at: "2023-07-20T14:09:00-04:00", expected: []TripEvent{event(TripCompleted, "2023-07-20T14:09:00-04:00")}},
+	}
+
+	ctx := context.Background()
+	oracle := NewMockStateOracle(t)
+	store := NewMemoryStore()
+	testInstance := NewStateProcessor(oracle, store)
+	for _, pull := range pulls {
+		testInstance.now = func() time.Time {
+			return *timeOrDie(pull.at)
+		}
+		oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: pull.trips, Feeds: []FeedReport{{Name: "g"}}}, nil).Once()
+		res, err := testInstance.ProcessUpdates(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, pull.expected, res.TripEvents, pull.at)
+	}
+}
+
+func TestStateProcessor_ProcessUpdates_CompletedTrips(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+	scheduledTrack, actualTrack := "B1", "B2"
+	stops := []StopTimeUpdate{
+		// the origin only has a departure
+		{StopID: "F27N", Departure: timeOrDie("2023-07-20T14:04:11-04:00")},
+		{StopID: "F26N", Arrival: timeOrDie("2023-07-20T14:06:11-04:00"), Departure: timeOrDie("2023-07-20T14:06:11-04:00"), ScheduledTrack: &scheduledTrack, ActualTrack: &actualTrack},
+		{StopID: "F25N", Arrival: timeOrDie("2023-07-20T14:08:11-04:00"), Departure: timeOrDie("2023-07-20T14:08:11-04:00")},
+	}
+	trip := func(stops ...StopTimeUpdate) []TripUpdate {
+		return []TripUpdate{{TripId: "084421_G..N", RouteId: "G", IsAssigned: true, Feed: "g", StopTimeUpdate: stops}}
+	}
+	completedStops := func(inferred ...bool) []StopTimeUpdate {
+		ret := make([]StopTimeUpdate, len(stops))
+		copy(ret, stops)
+		for i := range ret {
+			ret[i].IsComplete = true
+			ret[i].Inferred = inferred[i]
+		}
+		return ret
+	}
+
+	type pull struct {
+		at    string
+		trips []TripUpdate
+	}
+	testCases := []struct {
+		name     string
+		pulls    []pull
+		expected []CompletedTrip
+	}{
+		{
+			name: "every stop seen to drop off",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(stops...)},
+				{at: "2023-07-20T14:05:00-04:00", trips: trip(stops[1:]...)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(stops[2:]...)},
+				{at: "2023-07-20T14:09:00-04:00", trips: trip()},
+			},
+			expected: []CompletedTrip{{
+				TripID:       "084421_G..N",
+				RouteID:      "G",
+				IsAssigned:   true,
+				Origin:       "F27N",
+				Terminus:     "F25N",
+				DepartAt:     *timeOrDie("2023-07-20T14:04:11-04:00"),
+				ArriveAt:     *timeOrDie("2023-07-20T14:08:11-04:00"),
+				Stops:        completedStops(false, false, false),
+				TrackChanges: 1,
+			}},
+		},
+		{
+			// the whole trip dropping off the feed means its last stop could only be assumed
+			name: "last stop inferred",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(stops...)},
+				{at: "2023-07-20T14:05:00-04:00", trips: trip(stops[1:]...)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(stops[2:]...)},
+				{at: "2023-07-20T14:09:00-04:00"},
+			},
+			expected: []CompletedTrip{{
+				TripID:           "084421_G..N",
+				RouteID:          "G",
+				IsAssigned:       true,
+				Origin:           "F27N",
+				Terminus:         "F25N",
+				DepartAt:         *timeOrDie("2023-07-20T14:04:11-04:00"),
+				ArriveAt:         *timeOrDie("2023-07-20T14:08:11-04:00"),
+				Stops:            completedStops(false, false, true),
+				TrackChanges:     1,
+				HasInferredStops: true,
+			}},
+		},
+		{
+			name: "discarded trips aren't summarized",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(stops...)},
+				{at: "2023-07-20T14:04:05-04:00"},
+			},
+			expected: []CompletedTrip{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			oracle := NewMockStateOracle(t)
+			testInstance := NewStateProcessor(oracle, NewMemoryStore())
+			got := make([]CompletedTrip, 0)
+			for _, p := range tc.pulls {
+				testInstance.now = func() time.Time {
+					return *timeOrDie(p.at)
+				}
+				oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: p.trips, Feeds: []FeedReport{{Name: "g"}}}, nil).Once()
+				res, err := testInstance.ProcessUpdates(ctx)
+				require.NoError(t, err)
+				got = append(got, res.CompletedTrips...)
+			}
+			assert.Equal(t, tc.expected, got)
+			// every trip that completes runs from 14:04:11 to 14:08:11
+			for _, c := range got {
+				assert.Equal(t, time.Minute*4, c.Duration())
+			}
+		})
+	}
+}
+
+func TestStateProcessor_ProcessUpdates_StopVisits(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+	scheduledTrack, actualTrack := "B1", "B2"
+	stops := func(f26Arrival, f26Departure string) []StopTimeUpdate {
+		return []StopTimeUpdate{
+			{StopID: "F27N", Departure: timeOrDie("2023-07-20T14:04:11-04:00")},
+			{StopID: "F26N", Arrival: timeOrDie(f26Arrival), Departure: timeOrDie(f26Departure), ScheduledTrack: &scheduledTrack, ActualTrack: &actualTrack},
+			{StopID: "F25N", Arrival: timeOrDie("2023-07-20T14:08:11-04:00"), Departure: timeOrDie("2023-07-20T14:08:11-04:00")},
+		}
+	}
+	trip := func(stops ...StopTimeUpdate) []TripUpdate {
+		return []TripUpdate{{TripId: "084421_G..N", RouteId: "G", TrainId: "1G 1404 CHU/CRS", Feed: "g", StopTimeUpdate: stops}}
+	}
+	visit := func(stop StopTimeUpdate, dwell time.Duration, inferred bool) StopVisit {
+		return StopVisit{
+			StopID:         stop.StopID,
+			TripID:         "084421_G..N",
+			RouteID:        "G",
+			TrainID:        "1G 1404 CHU/CRS",
+			Arrival:        stop.Arrival,
+			Departure:      stop.Departure,
+			Dwell:          dwell,
+			ScheduledTrack: stop.ScheduledTrack,
+			ActualTrack:    stop.ActualTrack,
+			Inferred:       inferred,
+		}
+	}
+
+	dwelling := stops("2023-07-20T14:06:11-04:00", "2023-07-20T14:06:41-04:00")
+	// predictions that have the train leaving before it arrives can't give a dwell
+	backwards := stops("2023-07-20T14:06:41-04:00", "2023-07-20T14:06:11-04:00")
+
+	type pull struct {
+		at    string
+		trips []TripUpdate
+	}
+	testCases := []struct {
+		name     string
+		pulls    []pull
+		expected []StopVisit
+	}{
+		{
+			name: "dwell",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(dwelling...)},
+				{at: "2023-07-20T14:05:00-04:00", trips: trip(dwelling[1:]...)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(dwelling[2:]...)},
+				{at: "2023-07-20T14:09:00-04:00", trips: trip()},
+			},
+			expected: []StopVisit{
+				visit(dwelling[0], 0, false),
+				visit(dwelling[1], time.Second*30, false),
+				visit(dwelling[2], 0, false),
+			},
+		},
+		{
+			name: "departure before arrival",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(backwards...)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(backwards[2:]...)},
+				{at: "2023-07-20T14:09:00-04:00", trips: trip()},
+			},
+			expected: []StopVisit{
+				visit(backwards[0], 0, false),
+				visit(backwards[1], 0, false),
+				visit(backwards[2], 0, false),
+			},
+		},
+		{
+			// the whole trip dropping off the feed means its last stop could only be assumed
+			name: "trip dropped off the feed",
+			pulls: []pull{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(dwelling...)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(dwelling[2:]...)},
+				{at: "2023-07-20T14:09:00-04:00"},
+			},
+			expected: []StopVisit{
+				visit(dwelling[0], 0, false),
+				visit(dwelling[1], time.Second*30, false),
+				visit(dwelling[2], 0, true),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			oracle := NewMockStateOracle(t)
+			testInstance := NewStateProcessor(oracle, NewMemoryStore())
+			got := make([]StopVisit, 0)
+			for _, p := range tc.pulls {
+				testInstance.now = func() time.Time {
+					return *timeOrDie(p.at)
+				}
+				oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: p.trips, Feeds: []FeedReport{{Name: "g"}}}, nil).Once()
+				res, err := testInstance.ProcessUpdates(ctx)
+				require.NoError(t, err)
+				got = append(got, res.StopVisits...)
+			}
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+type routeNameEnricher map[string]string
+
+func (r routeNameEnricher) Enrich(segment Segment) Segment {
+	segment.Details = &SegmentDetails{Route: RouteDetails{ShortName: r[segment.RouteID]}}
+	return segment
+}
+
+func TestStateProcessor_ProcessUpdates_Enricher(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+	stops := []StopTimeUpdate{
+		{StopID: "F27N", Departure: timeOrDie("2023-07-20T14:04:11-04:00")},
+		{StopID: "F26N", Arrival: timeOrDie("2023-07-20T14:06:11-04:00"), Departure: timeOrDie("2023-07-20T14:06:41-04:00")},
+	}
+	trip := func(stops ...StopTimeUpdate) TripUpdate {
+		return TripUpdate{TripId: "084421_G..N", RouteId: "G", StopTimeUpdate: stops}
+	}
+
+	ctx := context.Background()
+	oracle := NewMockStateOracle(t)
+	testInstance := NewStateProcessor(oracle, NewMemoryStore(), WithSegmentEnricher(routeNameEnricher{"G": "G Crosstown"}))
+	var testTime time.Time
+	testInstance.now = func() time.Time {
+		return testTime
+	}
+
+	testTime = *timeOrDie("2023-07-20T14:04:00-04:00")
+	oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: []TripUpdate{trip(stops...)}}, nil).Once()
+	res, err := testInstance.ProcessUpdates(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, res.CompletedSegments)
+
+	testTime = *timeOrDie("2023-07-20T14:07:00-04:00")
+	oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: []TripUpdate{trip()}}, nil).Once()
+	res, err = testInstance.ProcessUpdates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Segment{{
+		FromStation: "F27N",
+		ToStation:   "F26N",
+		DepartAt:    *timeOrDie("2023-07-20T14:04:11-04:00"),
+		ArriveAt:    *timeOrDie("2023-07-20T14:06:11-04:00"),
+		TripID:      "084421_G..N",
+		RouteID:     "G",
+		Details:     &SegmentDetails{Route: RouteDetails{ShortName: "G Crosstown"}},
+	}}, res.CompletedSegments)
+}
+
+func TestStateProcessor_ProcessUpdates_MissingTimes(t *testing.T) {
+	timeOrDie := func(str string) *time.Time {
+		ret, err := time.Parse(time.RFC3339, str)
+		require.NoError(t, err)
+		return &ret
+	}
+	trip := func(stops ...StopTimeUpdate) []TripUpdate {
+		return []TripUpdate{{TripId: "084421_G..N", RouteId: "G", Feed: "g", StopTimeUpdate: stops}}
+	}
+	origin := StopTimeUpdate{StopID: "F27N", Departure: timeOrDie("2023-07-20T14:04:11-04:00")}
+	terminus := StopTimeUpdate{StopID: "F25N", Arrival: timeOrDie("2023-07-20T14:08:11-04:00")}
+
+	testCases := []struct {
+		name     string
+		middle   StopTimeUpdate
+		expected []Segment
+	}{
+		{
+			name:     "no times",
+			middle:   StopTimeUpdate{StopID: "F26N"},
+			expected: []Segment{},
+		},
+		{
+			name:   "departure only",
+			middle: StopTimeUpdate{StopID: "F26N", Departure: timeOrDie("2023-07-20T14:06:41-04:00")},
+			expected: []Segment{
+				{
+					FromStation: "F27N",
+					ToStation:   "F26N",
+					DepartAt:    *timeOrDie("2023-07-20T14:04:11-04:00"),
+					ArriveAt:    *timeOrDie("2023-07-20T14:06:41-04:00"),
+					TripID:      "084421_G..N",
+					RouteID:     "G",
+				},
+				{
+					FromStation: "F26N",
+					ToStation:   "F25N",
+					DepartAt:    *timeOrDie("2023-07-20T14:06:41-04:00"),
+					ArriveAt:    *timeOrDie("2023-07-20T14:08:11-04:00"),
+					TripID:      "084421_G..N",
+					RouteID:     "G",
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pulls := []struct {
+				at    string
+				trips []TripUpdate
+			}{
+				{at: "2023-07-20T14:04:00-04:00", trips: trip(origin, tc.middle, terminus)},
+				{at: "2023-07-20T14:07:00-04:00", trips: trip(terminus)},
+				{at: "2023-07-20T14:09:00-04:00", trips: trip()},
+			}
+			ctx := context.Background()
+			oracle := NewMockStateOracle(t)
+			testInstance := NewStateProcessor(oracle, NewMemoryStore())
+			got := make([]Segment, 0)
+			var res StateUpdateResults
+			for _, p := range pulls {
+				testInstance.now = func() time.Time {
+					return *timeOrDie(p.at)
+				}
+				oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: p.trips, Feeds: []FeedReport{{Name: "g"}}}, nil).Once()
+				var err error
+				res, err = testInstance.ProcessUpdates(ctx)
+				require.NoError(t, err)
+				got = append(got, res.CompletedSegments...)
+			}
+			assert.Equal(t, tc.expected, got)
+			// the trip still runs to completion
+			require.Len(t, res.CompletedTrips, 1)
+			assert.Len(t, res.CompletedTrips[0].Stops, 3)
+		})
+	}
+}
+
+type failingStore struct {
+	priorErr  error
+	recordErr error
+}
+
+func (s failingStore) PriorState(_ context.Context) ([]TripUpdate, error) {
+	return nil, s.priorErr
+}
+
+func (s failingStore) RecordState(_ context.Context, _ []TripUpdate) error {
+	return s.recordErr
+}
+
+func TestStateProcessor_ProcessUpdates_Errors(t *testing.T) {
+	diskFull := errors.New("disk full")
+	feedsDown := errors.New("feeds down")
+
+	testCases := []struct {
+		name          string
+		store         failingStore
+		oracleErr     error
+		expectOracle  bool
+		expectedStore *StoreError
+		expectedErr   error
+	}{
+		{
+			name:          "prior state",
+			store:         failingStore{priorErr: diskFull},
+			expectedStore: &StoreError{Op: "load", Err: diskFull},
+			expectedErr:   diskFull,
+		},
+		{
+			name:          "record state",
+			store:         failingStore{recordErr: diskFull},
+			expectOracle:  true,
+			expectedStore: &StoreError{Op: "record", Err: diskFull},
+			expectedErr:   diskFull,
+		},
+		{
+			name:         "oracle",
+			store:        failingStore{},
+			oracleErr:    feedsDown,
+			expectOracle: true,
+			expectedErr:  feedsDown,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			oracle := NewMockStateOracle(t)
+			if tc.expectOracle {
+				oracle.EXPECT().CurrentState(ctx).Return(SystemState{}, tc.oracleErr).Once()
+			}
+			testInstance := NewStateProcessor(oracle, tc.store)
+
+			_, err := testInstance.ProcessUpdates(ctx)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			var storeErr *StoreError
+			if tc.expectedStore != nil {
+				require.ErrorAs(t, err, &storeErr)
+				assert.Equal(t, tc.expectedStore, storeErr)
+			} else {
+				assert.False(t, errors.As(err, &storeErr))
+			}
+		})
+	}
+}
+
+func TestStateProcessor_Flush(t *testing.T) {
+	ctx := context.Background()
+	trips := []TripUpdate{{TripId: "084421_G..N", RouteId: "G", Feed: "g", StopTimeUpdate: []StopTimeUpdate{{StopID: "F27N"}}}}
+
+	oracle := NewMockStateOracle(t)
+	store := NewMemoryStore()
+	testInstance := NewStateProcessor(oracle, store)
+
+	// nothing to flush before a run has succeeded
+	require.NoError(t, testInstance.Flush(ctx))
+	prior, err := store.PriorState(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, prior)
+
+	oracle.EXPECT().CurrentState(ctx).Return(SystemState{TripUpdates: trips, Feeds: []FeedReport{{Name: "g"}}}, nil).Once()
+	_, err = testInstance.ProcessUpdates(ctx)
+	require.NoError(t, err)
+
+	// state lost after the run is put back by the flush
+	require.NoError(t, store.RecordState(ctx, []TripUpdate{}))
+	require.NoError(t, testInstance.Flush(ctx))
+	prior, err = store.PriorState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, trips, prior)
+
+	diskFull := errors.New("disk full")
+	testInstance.store = failingStore{recordErr: diskFull}
+	err = testInstance.Flush(ctx)
+	assert.Equal(t, &StoreError{Op: "record", Err: diskFull}, err)
+}
@@ -1,4 +1,4 @@
 package static
 
-// Types generated and deserialized from MTA static data. Not everything is deserialized
-// yet. See http://web.mta.info/developers/data/nyct/subway/google_transit.zip
+// Types generated and deserialized from MTA static data, either a file at a time or the whole bundle as an indexed
+// Schedule via Load. See http://web.mta.info/developers/data/nyct/subway/google_transit.zip
@@ -0,0 +1,83 @@
+package static
+
+import (
+	"fmt"
+	"time"
+)
+
+// ServiceTime is a GTFS time of day, measured from noon minus 12 hours on the service day. Trips running past midnight
+// carry times past 24:00:00 rather than rolling over to the next day. Empty values unmarshal to zero.
+type ServiceTime time.Duration
+
+func (s *ServiceTime) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*s = 0
+		return nil
+	}
+	var h, m, sec int
+	n, err := fmt.Sscanf(string(text), "%d:%d:%d", &h, &m, &sec)
+	if err != nil || n != 3 || h < 0 || m < 0 || m > 59 || sec < 0 || sec > 59 {
+		return fmt.Errorf("invalid GTFS time %q", text)
+	}
+	*s = ServiceTime(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second)
+	return nil
+}
+
+func (s ServiceTime) String() string {
+	d := time.Duration(s)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// On returns the instant this time falls at on the given service day, in loc
+func (s ServiceTime) On(day Date, loc *time.Location) time.Time {
+	// per the spec times are relative to noon minus 12 hours, which differs from midnight on days clocks change
+	noon := time.Date(day.Year, day.Month, day.Day, 12, 0, 0, 0, loc)
+	return noon.Add(-12 * time.Hour).Add(time.Duration(s))
+}
+
+// Date is a calendar date as used by GTFS service days, formatted YYYYMMDD
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the date t falls on in t's location
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{Year: y, Month: m, Day: d}
+}
+
+func (d *Date) UnmarshalText(text []byte) error {
+	t, err := time.Parse("20060102", string(text))
+	if err != nil {
+		return fmt.Errorf("invalid GTFS date %q", text)
+	}
+	*d = DateOf(t)
+	return nil
+}
+
+func (d Date) String() string {
+	return fmt.Sprintf("%04d%02d%02d", d.Year, d.Month, d.Day)
+}
+
+// AddDays returns the date the given number of days after (or before, if negative) d
+func (d Date) AddDays(days int) Date {
+	return DateOf(d.midday().AddDate(0, 0, days))
+}
+
+func (d Date) Weekday() time.Weekday {
+	return d.midday().Weekday()
+}
+
+func (d Date) Before(other Date) bool {
+	return d.midday().Before(other.midday())
+}
+
+func (d Date) After(other Date) bool {
+	return d.midday().After(other.midday())
+}
+
+func (d Date) midday() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 12, 0, 0, 0, time.UTC)
+}
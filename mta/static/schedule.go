@@ -0,0 +1,185 @@
+package static
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// Schedule is a complete static GTFS bundle, indexed for lookups
+type Schedule struct {
+	Routes        []Route
+	Stops         []Stop
+	Trips         []Trip
+	StopTimes     []StopTime
+	Calendars     []Calendar
+	CalendarDates []CalendarDate
+	Shapes        []ShapePoint
+	Transfers     []Transfer
+
+	routes        map[string]Route
+	stops         map[string]Stop
+	trips         map[string]Trip
+	stopTimes     map[string][]StopTime
+	shapes        map[string][]ShapePoint
+	transfersFrom map[string][]Transfer
+	calendars     map[string]Calendar
+	exceptions    map[string]map[Date]int
+}
+
+// Load reads the static GTFS bundle at path, which may be the zip file published by the MTA (google_transit.zip) or a
+// directory it has been extracted to
+func Load(path string) (*Schedule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return LoadFS(os.DirFS(path))
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer r.Close()
+	return LoadFS(r)
+}
+
+// LoadFS reads a static GTFS bundle from the root of fsys. Routes, stops, trips, stop times and calendar are required,
+// calendar dates, shapes and transfers are loaded if present.
+func LoadFS(fsys fs.FS) (*Schedule, error) {
+	var err error
+	ret := &Schedule{}
+	if ret.Routes, err = LoadCSV[Route](fsys, "routes.txt"); err != nil {
+		return nil, err
+	}
+	if ret.Stops, err = LoadCSV[Stop](fsys, "stops.txt"); err != nil {
+		return nil, err
+	}
+	if ret.Trips, err = LoadCSV[Trip](fsys, "trips.txt"); err != nil {
+		return nil, err
+	}
+	if ret.StopTimes, err = LoadCSV[StopTime](fsys, "stop_times.txt"); err != nil {
+		return nil, err
+	}
+	if ret.Calendars, err = LoadCSV[Calendar](fsys, "calendar.txt"); err != nil {
+		return nil, err
+	}
+	if ret.CalendarDates, err = loadOptionalCSV[CalendarDate](fsys, "calendar_dates.txt"); err != nil {
+		return nil, err
+	}
+	if ret.Shapes, err = loadOptionalCSV[ShapePoint](fsys, "shapes.txt"); err != nil {
+		return nil, err
+	}
+	if ret.Transfers, err = loadOptionalCSV[Transfer](fsys, "transfers.txt"); err != nil {
+		return nil, err
+	}
+	ret.index()
+	return ret, nil
+}
+
+func loadOptionalCSV[T any](fsys fs.FS, filename string) ([]T, error) {
+	ret, err := LoadCSV[T](fsys, filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make([]T, 0), nil
+	}
+	return ret, err
+}
+
+func (s *Schedule) index() {
+	s.routes = make(map[string]Route, len(s.Routes))
+	for _, r := range s.Routes {
+		s.routes[r.RouteID] = r
+	}
+	s.stops = make(map[string]Stop, len(s.Stops))
+	for _, st := range s.Stops {
+		s.stops[st.StopID] = st
+	}
+	s.trips = make(map[string]Trip, len(s.Trips))
+	for _, t := range s.Trips {
+		s.trips[t.TripID] = t
+	}
+	s.stopTimes = make(map[string][]StopTime)
+	for _, st := range s.StopTimes {
+		s.stopTimes[st.TripID] = append(s.stopTimes[st.TripID], st)
+	}
+	for _, sts := range s.stopTimes {
+		sort.Slice(sts, func(i, j int) bool {
+			return sts[i].StopSequence < sts[j].StopSequence
+		})
+	}
+	s.shapes = make(map[string][]ShapePoint)
+	for _, p := range s.Shapes {
+		s.shapes[p.ShapeID] = append(s.shapes[p.ShapeID], p)
+	}
+	for _, points := range s.shapes {
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Sequence < points[j].Sequence
+		})
+	}
+	s.transfersFrom = make(map[string][]Transfer)
+	for _, t := range s.Transfers {
+		s.transfersFrom[t.FromStopID] = append(s.transfersFrom[t.FromStopID], t)
+	}
+	s.calendars = make(map[string]Calendar, len(s.Calendars))
+	for _, c := range s.Calendars {
+		s.calendars[c.ServiceID] = c
+	}
+	s.exceptions = make(map[string]map[Date]int)
+	for _, d := range s.CalendarDates {
+		if s.exceptions[d.ServiceID] == nil {
+			s.exceptions[d.ServiceID] = make(map[Date]int)
+		}
+		s.exceptions[d.ServiceID][d.Date] = d.ExceptionType
+	}
+}
+
+func (s *Schedule) Route(routeID string) (Route, bool) {
+	r, ok := s.routes[routeID]
+	return r, ok
+}
+
+func (s *Schedule) Stop(stopID string) (Stop, bool) {
+	st, ok := s.stops[stopID]
+	return st, ok
+}
+
+func (s *Schedule) Trip(tripID string) (Trip, bool) {
+	t, ok := s.trips[tripID]
+	return t, ok
+}
+
+// StopTimesFor returns the stops made by the trip, in stop sequence order
+func (s *Schedule) StopTimesFor(tripID string) []StopTime {
+	return s.stopTimes[tripID]
+}
+
+// Shape returns the points of the shape, in sequence order
+func (s *Schedule) Shape(shapeID string) []ShapePoint {
+	return s.shapes[shapeID]
+}
+
+// TransfersFrom returns the transfers that can be made from the stop
+func (s *Schedule) TransfersFrom(stopID string) []Transfer {
+	return s.transfersFrom[stopID]
+}
+
+// ServiceRunsOn reports if the service operates on the given day, taking calendar date exceptions into account
+func (s *Schedule) ServiceRunsOn(serviceID string, day Date) bool {
+	switch s.exceptions[serviceID][day] {
+	case ServiceAdded:
+		return true
+	case ServiceRemoved:
+		return false
+	}
+	cal, ok := s.calendars[serviceID]
+	return ok && cal.ActiveOn(day)
+}
+
+// TripMatcher returns a matcher resolving realtime trip IDs against this schedule
+func (s *Schedule) TripMatcher() *TripMatcher {
+	return NewTripMatcher(s.Trips, s.ServiceRunsOn)
+}
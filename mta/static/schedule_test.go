@@ -0,0 +1,143 @@
+package static
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipFixtures writes the GTFS fixtures into a zip laid out like google_transit.zip
+func zipFixtures(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "google_transit.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, name := range []string{"routes.txt", "stops.txt", "trips.txt", "stop_times.txt", "calendar.txt", "calendar_dates.txt", "shapes.txt", "transfers.txt"} {
+		b, err := os.ReadFile(fixtures + name)
+		require.NoError(t, err)
+		entry, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = entry.Write(b)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name string
+		path func(t *testing.T) string
+	}{
+		{
+			name: "directory",
+			path: func(t *testing.T) string {
+				return fixtures
+			},
+		},
+		{
+			name: "zip",
+			path: zipFixtures,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := Load(tc.path(t))
+			require.NoError(t, err)
+
+			assert.Len(t, schedule.Routes, 4)
+			assert.Len(t, schedule.Stops, 9)
+			assert.Len(t, schedule.Trips, 2)
+			assert.Len(t, schedule.StopTimes, 5)
+			assert.Len(t, schedule.Calendars, 2)
+			assert.Len(t, schedule.CalendarDates, 2)
+			assert.Len(t, schedule.Transfers, 3)
+
+			route, ok := schedule.Route("A")
+			require.True(t, ok)
+			assert.Equal(t, "8 Avenue Express", route.LongName)
+
+			stop, ok := schedule.Stop("103N")
+			require.True(t, ok)
+			assert.Equal(t, "238 St", stop.Name)
+			_, ok = schedule.Stop("F27N")
+			assert.False(t, ok)
+
+			trip, ok := schedule.Trip("ASP23GEN-1037-Sunday-00_000600_1..S03R")
+			require.True(t, ok)
+			assert.Equal(t, "South Ferry", trip.Headsign)
+
+			stopIDs := make([]string, 0)
+			for _, st := range schedule.StopTimesFor(trip.TripID) {
+				stopIDs = append(stopIDs, st.StopID)
+			}
+			assert.Equal(t, []string{"101S", "103S", "104S"}, stopIDs)
+
+			shape := schedule.Shape(trip.ShapeID)
+			require.Len(t, shape, 3)
+			for i, p := range shape {
+				assert.Equal(t, i, p.Sequence)
+			}
+
+			assert.Equal(t, []Transfer{{FromStopID: "112", ToStopID: "A09", TransferType: 2, MinTransferTimeSecs: 180}}, schedule.TransfersFrom("112"))
+		})
+	}
+}
+
+func TestSchedule_ServiceRunsOn(t *testing.T) {
+	schedule, err := Load(fixtures)
+	require.NoError(t, err)
+
+	sunday := Date{Year: 2023, Month: time.July, Day: 23}
+	monday := Date{Year: 2023, Month: time.July, Day: 24}
+	laborDay := Date{Year: 2023, Month: time.September, Day: 4}
+
+	assert.True(t, schedule.ServiceRunsOn("ASP23GEN-1037-Sunday-00", sunday))
+	assert.False(t, schedule.ServiceRunsOn("ASP23GEN-1037-Sunday-00", monday))
+	assert.True(t, schedule.ServiceRunsOn("ASP23GEN-1038-Weekday-00", monday))
+	// holidays run the sunday schedule
+	assert.True(t, schedule.ServiceRunsOn("ASP23GEN-1037-Sunday-00", laborDay))
+	assert.False(t, schedule.ServiceRunsOn("ASP23GEN-1038-Weekday-00", laborDay))
+	assert.False(t, schedule.ServiceRunsOn("nope", sunday))
+}
+
+func TestLoadFS_Errors(t *testing.T) {
+	valid := fstest.MapFS{}
+	for _, name := range []string{"routes.txt", "stops.txt", "trips.txt", "stop_times.txt", "calendar.txt"} {
+		b, err := os.ReadFile(fixtures + name)
+		require.NoError(t, err)
+		valid[name] = &fstest.MapFile{Data: b}
+	}
+
+	// the optional files can be left out
+	schedule, err := LoadFS(valid)
+	require.NoError(t, err)
+	assert.Empty(t, schedule.Transfers)
+	assert.Empty(t, schedule.TransfersFrom("101"))
+
+	missing := fstest.MapFS{}
+	for k, v := range valid {
+		missing[k] = v
+	}
+	delete(missing, "stops.txt")
+	_, err = LoadFS(missing)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	malformed := fstest.MapFS{}
+	for k, v := range valid {
+		malformed[k] = v
+	}
+	malformed["calendar.txt"] = &fstest.MapFile{Data: []byte("service_id,start_date\nfoo,tomorrow\n")}
+	_, err = LoadFS(malformed)
+	assert.ErrorContains(t, err, "parsing calendar.txt")
+
+	_, err = Load(filepath.Join(t.TempDir(), "nope.zip"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,7 +1,12 @@
 package static
 
 import (
+	"bytes"
+	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
+	"time"
 
 	csv "github.com/trimmer-io/go-csv"
 )
@@ -15,21 +20,141 @@ type Route struct {
 	Color       string `csv:"route_color"`
 }
 
+// Station is a record from the MTA's Stations.csv, describing a station as served by a single line. Stations sharing
+// a ComplexID are connected by free transfers.
 type Station struct {
-	StationID int     `csv:"Station ID"`
-	GTFSID    string  `csv:"GTFS Stop ID"`
-	Line      string  `csv:"Line"`
-	Name      string  `csv:"Stop Name"`
-	Borough   string  `csv:"Borough"`
-	Structure string  `csv:"Structure"`
-	Latitude  float64 `csv:"GTFS Latitude"`
-	Longitude float64 `csv:"GTFS Longitude"`
+	StationID int    `csv:"Station ID"`
+	ComplexID int    `csv:"Complex ID"`
+	GTFSID    string `csv:"GTFS Stop ID"`
+	// Division is the historical operator of the line, one of IRT, BMT, IND or SIR
+	Division      string    `csv:"Division"`
+	Line          string    `csv:"Line"`
+	Name          string    `csv:"Stop Name"`
+	Borough       string    `csv:"Borough"`
+	DaytimeRoutes RouteList `csv:"Daytime Routes"`
+	Structure     string    `csv:"Structure"`
+	Latitude      float64   `csv:"GTFS Latitude"`
+	Longitude     float64   `csv:"GTFS Longitude"`
+	// NorthLabel and SouthLabel describe where trains in each direction are headed, eg Manhattan. Terminals leave the
+	// direction trains don't run in empty.
+	NorthLabel string `csv:"North Direction Label"`
+	SouthLabel string `csv:"South Direction Label"`
+	ADA        ADA    `csv:"ADA"`
+	// ADANotes qualifies partially accessible stations, eg Manhattan-bound only
+	ADANotes string `csv:"ADA Direction Notes"`
+	// ADANorthbound and ADASouthbound are only given for partially accessible stations, see NorthboundADA and
+	// SouthboundADA
+	ADANorthbound ADA `csv:"ADA NB"`
+	ADASouthbound ADA `csv:"ADA SB"`
+	// CapitalOutageNorthbound and CapitalOutageSouthbound note accessibility lost to capital work, empty if none
+	CapitalOutageNorthbound string `csv:"Capital Outage NB"`
+	CapitalOutageSouthbound string `csv:"Capital Outage SB"`
+}
+
+type LocationType int
+
+const (
+	// LocationPlatform is a stop where trains pick up and drop off, in NYCT these are the directional platforms
+	// (F27N, F27S)
+	LocationPlatform LocationType = 0
+	// LocationStation is a parent station grouping platforms (F27)
+	LocationStation      LocationType = 1
+	LocationEntrance     LocationType = 2
+	LocationGenericNode  LocationType = 3
+	LocationBoardingArea LocationType = 4
+)
+
+// UnmarshalText parses location_type, which GTFS allows to be empty for platforms
+func (l *LocationType) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*l = LocationPlatform
+		return nil
+	}
+	v, err := strconv.Atoi(string(text))
+	if err != nil || v < 0 || v > 4 {
+		return fmt.Errorf("invalid location_type %q", text)
+	}
+	*l = LocationType(v)
+	return nil
+}
+
+type Stop struct {
+	StopID       string       `csv:"stop_id"`
+	Name         string       `csv:"stop_name"`
+	Latitude     float64      `csv:"stop_lat"`
+	Longitude    float64      `csv:"stop_lon"`
+	LocationType LocationType `csv:"location_type"`
+	// ParentStation is the stop ID of the station a platform belongs to, empty for stations themselves
+	ParentStation string `csv:"parent_station"`
 }
 
 type StopTime struct {
-	TripID       string `csv:"trip_id"`
-	StopID       string `csv:"stop_id"`
-	StopSequence int    `csv:"stop_sequence"`
+	TripID        string      `csv:"trip_id"`
+	ArrivalTime   ServiceTime `csv:"arrival_time"`
+	DepartureTime ServiceTime `csv:"departure_time"`
+	StopID        string      `csv:"stop_id"`
+	StopSequence  int         `csv:"stop_sequence"`
+}
+
+type Trip struct {
+	RouteID   string `csv:"route_id"`
+	TripID    string `csv:"trip_id"`
+	ServiceID string `csv:"service_id"`
+	Headsign  string `csv:"trip_headsign"`
+	// DirectionID is 0 for northbound trips and 1 for southbound
+	DirectionID int    `csv:"direction_id"`
+	ShapeID     string `csv:"shape_id"`
+}
+
+// Calendar is the days of the week a service runs between StartDate and EndDate inclusive
+type Calendar struct {
+	ServiceID string `csv:"service_id"`
+	Monday    int    `csv:"monday"`
+	Tuesday   int    `csv:"tuesday"`
+	Wednesday int    `csv:"wednesday"`
+	Thursday  int    `csv:"thursday"`
+	Friday    int    `csv:"friday"`
+	Saturday  int    `csv:"saturday"`
+	Sunday    int    `csv:"sunday"`
+	StartDate Date   `csv:"start_date"`
+	EndDate   Date   `csv:"end_date"`
+}
+
+// ActiveOn reports if the service runs on the given day
+func (c Calendar) ActiveOn(day Date) bool {
+	if day.Before(c.StartDate) || day.After(c.EndDate) {
+		return false
+	}
+	runs := map[time.Weekday]int{
+		time.Monday:    c.Monday,
+		time.Tuesday:   c.Tuesday,
+		time.Wednesday: c.Wednesday,
+		time.Thursday:  c.Thursday,
+		time.Friday:    c.Friday,
+		time.Saturday:  c.Saturday,
+		time.Sunday:    c.Sunday,
+	}
+	return runs[day.Weekday()] == 1
+}
+
+// CalendarDate adds or removes a service on a single day, overriding Calendar
+type CalendarDate struct {
+	ServiceID string `csv:"service_id"`
+	Date      Date   `csv:"date"`
+	// ExceptionType is 1 if service is added on the date, 2 if removed
+	ExceptionType int `csv:"exception_type"`
+}
+
+const (
+	ServiceAdded   = 1
+	ServiceRemoved = 2
+)
+
+type ShapePoint struct {
+	ShapeID   string  `csv:"shape_id"`
+	Latitude  float64 `csv:"shape_pt_lat"`
+	Longitude float64 `csv:"shape_pt_lon"`
+	Sequence  int     `csv:"shape_pt_sequence"`
 }
 
 type Transfer struct {
@@ -40,7 +165,7 @@ type Transfer struct {
 }
 
 // MustLoad populates the slice of type T with the data contained in the file at prefix + filename,
-// returning error if any errors are encountered. See go-csv docs for 'csv' tag details
+// panicking if any errors are encountered. See go-csv docs for 'csv' tag details
 func MustLoad[T any](prefix, filename string, dest *[]T) {
 	b, err := os.ReadFile(prefix + filename)
 	if err != nil {
@@ -51,3 +176,18 @@ func MustLoad[T any](prefix, filename string, dest *[]T) {
 		panic(err)
 	}
 }
+
+// LoadCSV returns the records of type T contained in the named file of fsys. See go-csv docs for 'csv' tag details
+func LoadCSV[T any](fsys fs.FS, filename string) ([]T, error) {
+	b, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	// some publishers (the MTA included, on occasion) start files with a byte order mark
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	ret := make([]T, 0)
+	if err := csv.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return ret, nil
+}
@@ -2,8 +2,10 @@ package static
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const fixtures = "./fixtures/"
@@ -46,50 +48,125 @@ func TestLoad_Routes(t *testing.T) {
 
 func TestLoad_Stations(t *testing.T) {
 	exp := []Station{{
-		StationID: 1,
-		GTFSID:    "R01",
-		Line:      "Astoria",
-		Name:      "Astoria-Ditmars Blvd",
-		Borough:   "Q",
-		Structure: "Elevated",
-		Latitude:  40.775036,
-		Longitude: -73.912034,
-	}, {
-		StationID: 7,
-		GTFSID:    "R11",
-		Line:      "Astoria",
-		Name:      "Lexington Av/59 St",
-		Borough:   "M",
-		Structure: "Subway",
-		Latitude:  40.76266,
-		Longitude: -73.967258,
-	}, {
-		StationID: 79,
-		GTFSID:    "N10",
-		Line:      "Sea Beach",
-		Name:      "86 St",
-		Borough:   "Bk",
-		Structure: "Open Cut",
-		Latitude:  40.592721,
-		Longitude: -73.97823,
-	}, {
-		StationID: 120,
-		GTFSID:    "L08",
-		Line:      "Canarsie",
-		Name:      "Bedford Av",
-		Borough:   "Bk",
-		Structure: "Subway",
-		Latitude:  40.717304,
-		Longitude: -73.956872,
-	}, {
-		StationID: 143,
-		GTFSID:    "A02",
-		Line:      "8th Av - Fulton St",
-		Name:      "Inwood-207 St",
-		Borough:   "M",
-		Structure: "Subway",
-		Latitude:  40.868072,
-		Longitude: -73.919899,
+		StationID:     1,
+		ComplexID:     1,
+		GTFSID:        "R01",
+		Division:      "BMT",
+		Line:          "Astoria",
+		Name:          "Astoria-Ditmars Blvd",
+		Borough:       "Q",
+		DaytimeRoutes: RouteList{"N", "W"},
+		Structure:     "Elevated",
+		Latitude:      40.775036,
+		Longitude:     -73.912034,
+		SouthLabel:    "Manhattan",
+		ADA:           ADANotAccessible,
+	}, {
+		StationID:     7,
+		ComplexID:     613,
+		GTFSID:        "R11",
+		Division:      "BMT",
+		Line:          "Astoria",
+		Name:          "Lexington Av/59 St",
+		Borough:       "M",
+		DaytimeRoutes: RouteList{"N", "W", "R"},
+		Structure:     "Subway",
+		Latitude:      40.76266,
+		Longitude:     -73.967258,
+		NorthLabel:    "Queens",
+		SouthLabel:    "Downtown & Brooklyn",
+		ADA:           ADANotAccessible,
+	}, {
+		StationID:     79,
+		ComplexID:     79,
+		GTFSID:        "N10",
+		Division:      "BMT",
+		Line:          "Sea Beach",
+		Name:          "86 St",
+		Borough:       "Bk",
+		DaytimeRoutes: RouteList{"N"},
+		Structure:     "Open Cut",
+		Latitude:      40.592721,
+		Longitude:     -73.97823,
+		NorthLabel:    "Manhattan",
+		SouthLabel:    "Coney Island",
+		ADA:           ADANotAccessible,
+	}, {
+		StationID:     120,
+		ComplexID:     120,
+		GTFSID:        "L08",
+		Division:      "BMT",
+		Line:          "Canarsie",
+		Name:          "Bedford Av",
+		Borough:       "Bk",
+		DaytimeRoutes: RouteList{"L"},
+		Structure:     "Subway",
+		Latitude:      40.717304,
+		Longitude:     -73.956872,
+		NorthLabel:    "Manhattan",
+		SouthLabel:    "Canarsie - Rockaway Parkway",
+		ADA:           ADAAccessible,
+	}, {
+		StationID:     143,
+		ComplexID:     143,
+		GTFSID:        "A02",
+		Division:      "IND",
+		Line:          "8th Av - Fulton St",
+		Name:          "Inwood-207 St",
+		Borough:       "M",
+		DaytimeRoutes: RouteList{"A"},
+		Structure:     "Subway",
+		Latitude:      40.868072,
+		Longitude:     -73.919899,
+		SouthLabel:    "Downtown & Brooklyn",
+		ADA:           ADAAccessible,
+	}, {
+		StationID:     293,
+		ComplexID:     293,
+		GTFSID:        "101",
+		Division:      "IRT",
+		Line:          "Broadway - 7Av",
+		Name:          "Van Cortlandt Park-242 St",
+		Borough:       "Bx",
+		DaytimeRoutes: RouteList{"1"},
+		Structure:     "Elevated",
+		Latitude:      40.889248,
+		Longitude:     -73.898583,
+		SouthLabel:    "Manhattan",
+		ADA:           ADANotAccessible,
+	}, {
+		StationID:     400,
+		ComplexID:     613,
+		GTFSID:        "629",
+		Division:      "IRT",
+		Line:          "Lexington Av",
+		Name:          "59 St",
+		Borough:       "M",
+		DaytimeRoutes: RouteList{"4", "5", "6"},
+		Structure:     "Subway",
+		Latitude:      40.762526,
+		Longitude:     -73.967967,
+		NorthLabel:    "Uptown & The Bronx",
+		SouthLabel:    "Downtown & Brooklyn",
+		ADA:           ADANotAccessible,
+	}, {
+		StationID:     10,
+		ComplexID:     10,
+		GTFSID:        "R15",
+		Division:      "BMT",
+		Line:          "Broadway - Brighton",
+		Name:          "49 St",
+		Borough:       "M",
+		DaytimeRoutes: RouteList{"N", "R", "W"},
+		Structure:     "Subway",
+		Latitude:      40.759901,
+		Longitude:     -73.984139,
+		NorthLabel:    "Uptown & Queens",
+		SouthLabel:    "Downtown & Brooklyn",
+		ADA:           ADAPartiallyAccessible,
+		ADANotes:      "Uptown & Queens",
+		ADANorthbound: ADAAccessible,
+		ADASouthbound: ADANotAccessible,
 	}}
 
 	out := make([]Station, 0)
@@ -98,18 +175,39 @@ func TestLoad_Stations(t *testing.T) {
 }
 
 func TestLoad_StopTimes(t *testing.T) {
+	serviceTime := func(h, m, s int) ServiceTime {
+		return ServiceTime(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+	}
 	exp := []StopTime{{
-		TripID:       "ASP23GEN-1037-Sunday-00_000600_1..S03R",
-		StopID:       "101S",
-		StopSequence: 1,
+		TripID:        "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+		ArrivalTime:   serviceTime(0, 6, 0),
+		DepartureTime: serviceTime(0, 6, 0),
+		StopID:        "101S",
+		StopSequence:  1,
 	}, {
-		TripID:       "ASP23GEN-1037-Sunday-00_000600_1..S03R",
-		StopID:       "103S",
-		StopSequence: 2,
+		TripID:        "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+		ArrivalTime:   serviceTime(0, 7, 30),
+		DepartureTime: serviceTime(0, 7, 30),
+		StopID:        "103S",
+		StopSequence:  2,
 	}, {
-		TripID:       "ASP23GEN-1037-Sunday-00_000600_1..S03R",
-		StopID:       "104S",
-		StopSequence: 3,
+		TripID:        "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+		ArrivalTime:   serviceTime(0, 9, 0),
+		DepartureTime: serviceTime(0, 9, 0),
+		StopID:        "104S",
+		StopSequence:  3,
+	}, {
+		TripID:        "ASP23GEN-1037-Sunday-00_143850_1..N03R",
+		ArrivalTime:   serviceTime(23, 58, 30),
+		DepartureTime: serviceTime(23, 58, 30),
+		StopID:        "104N",
+		StopSequence:  1,
+	}, {
+		TripID:        "ASP23GEN-1037-Sunday-00_143850_1..N03R",
+		ArrivalTime:   serviceTime(24, 0, 30),
+		DepartureTime: serviceTime(24, 1, 0),
+		StopID:        "103N",
+		StopSequence:  2,
 	}}
 
 	out := make([]StopTime, 0)
@@ -117,6 +215,71 @@ func TestLoad_StopTimes(t *testing.T) {
 	assert.EqualValues(t, exp, out)
 }
 
+func TestLoad_Trips(t *testing.T) {
+	exp := []Trip{{
+		RouteID:     "1",
+		TripID:      "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+		ServiceID:   "ASP23GEN-1037-Sunday-00",
+		Headsign:    "South Ferry",
+		DirectionID: 1,
+		ShapeID:     "1..S03R",
+	}, {
+		RouteID:     "1",
+		TripID:      "ASP23GEN-1037-Sunday-00_143850_1..N03R",
+		ServiceID:   "ASP23GEN-1037-Sunday-00",
+		Headsign:    "Van Cortlandt Park-242 St",
+		DirectionID: 0,
+		ShapeID:     "1..N03R",
+	}}
+
+	out := make([]Trip, 0)
+	MustLoad[Trip](fixtures, "trips.txt", &out)
+	assert.EqualValues(t, exp, out)
+}
+
+func TestLoad_Calendar(t *testing.T) {
+	out := make([]Calendar, 0)
+	MustLoad[Calendar](fixtures, "calendar.txt", &out)
+	assert.EqualValues(t, []Calendar{{
+		ServiceID: "ASP23GEN-1037-Sunday-00",
+		Sunday:    1,
+		StartDate: Date{Year: 2023, Month: time.July, Day: 2},
+		EndDate:   Date{Year: 2023, Month: time.November, Day: 5},
+	}, {
+		ServiceID: "ASP23GEN-1038-Weekday-00",
+		Monday:    1,
+		Tuesday:   1,
+		Wednesday: 1,
+		Thursday:  1,
+		Friday:    1,
+		StartDate: Date{Year: 2023, Month: time.July, Day: 3},
+		EndDate:   Date{Year: 2023, Month: time.November, Day: 3},
+	}}, out)
+
+	sunday := out[0]
+	assert.True(t, sunday.ActiveOn(Date{Year: 2023, Month: time.July, Day: 23}))
+	assert.False(t, sunday.ActiveOn(Date{Year: 2023, Month: time.July, Day: 24}))
+	assert.False(t, sunday.ActiveOn(Date{Year: 2023, Month: time.November, Day: 12}))
+}
+
+func TestServiceTime_On(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	var st ServiceTime
+	require.NoError(t, st.UnmarshalText([]byte("25:30:15")))
+	assert.Equal(t, "25:30:15", st.String())
+	assert.Equal(t, time.Date(2023, 7, 24, 1, 30, 15, 0, loc), st.On(Date{Year: 2023, Month: time.July, Day: 23}, loc))
+
+	// on the day clocks go back the day is 25 hours long, so noon minus 12 hours is an hour after midnight
+	require.NoError(t, st.UnmarshalText([]byte("12:00:00")))
+	assert.Equal(t, time.Date(2023, 11, 5, 12, 0, 0, 0, loc), st.On(Date{Year: 2023, Month: time.November, Day: 5}, loc))
+	require.NoError(t, st.UnmarshalText([]byte("00:30:00")))
+	assert.Equal(t, time.Date(2023, 11, 5, 1, 30, 0, 0, time.FixedZone("EDT", -4*60*60)).Unix(), st.On(Date{Year: 2023, Month: time.November, Day: 5}, loc).Unix())
+
+	assert.Error(t, st.UnmarshalText([]byte("7:61:00")))
+}
+
 func TestLoad_Transfers(t *testing.T) {
 	exp := []Transfer{{
 		FromStopID:          "101",
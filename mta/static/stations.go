@@ -0,0 +1,258 @@
+package static
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ADA is the accessibility of a station under the Americans with Disabilities Act
+type ADA int
+
+const (
+	// ADAUnknown is used where no accessibility is given, see Station.NorthboundADA
+	ADAUnknown ADA = iota
+	ADANotAccessible
+	ADAAccessible
+	// ADAPartiallyAccessible stations are only accessible in one direction, see Station.ADANotes
+	ADAPartiallyAccessible
+)
+
+var adaNames = map[ADA]string{
+	ADAUnknown:             "",
+	ADANotAccessible:       "none",
+	ADAAccessible:          "full",
+	ADAPartiallyAccessible: "partial",
+}
+
+// UnmarshalText parses the 0 (not accessible), 1 (accessible) or 2 (partially accessible) used by Stations.csv
+func (a *ADA) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "":
+		*a = ADAUnknown
+	case "0":
+		*a = ADANotAccessible
+	case "1":
+		*a = ADAAccessible
+	case "2":
+		*a = ADAPartiallyAccessible
+	default:
+		return fmt.Errorf("invalid ADA value %q", text)
+	}
+	return nil
+}
+
+// MarshalText renders the value as none, full or partial, empty if unknown
+func (a ADA) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a ADA) String() string {
+	return adaNames[a]
+}
+
+// RouteList is a space separated list of routes, as used for Stations.csv's Daytime Routes
+type RouteList []string
+
+func (r *RouteList) UnmarshalText(text []byte) error {
+	*r = strings.Fields(string(text))
+	return nil
+}
+
+// Serves reports if the route is in the list
+func (r RouteList) Serves(routeID string) bool {
+	for _, route := range r {
+		if route == routeID {
+			return true
+		}
+	}
+	return false
+}
+
+// NorthboundADA is the accessibility of the northbound platform
+func (s Station) NorthboundADA() ADA {
+	if s.ADANorthbound != ADAUnknown {
+		return s.ADANorthbound
+	}
+	return s.ADA
+}
+
+// SouthboundADA is the accessibility of the southbound platform
+func (s Station) SouthboundADA() ADA {
+	if s.ADASouthbound != ADAUnknown {
+		return s.ADASouthbound
+	}
+	return s.ADA
+}
+
+// DirectionLabel describes where trains heading in direction (N or S, as used in trip and platform IDs) are going, eg
+// Manhattan. Empty if trains don't run that way from the station or the direction isn't known.
+func (s Station) DirectionLabel(direction string) string {
+	switch direction {
+	case "N":
+		return s.NorthLabel
+	case "S":
+		return s.SouthLabel
+	}
+	return ""
+}
+
+// Complex is a group of stations connected by free transfers, such as Lexington Av/59 St where the N, R and W meet the
+// 4, 5 and 6
+type Complex struct {
+	ComplexID int
+	// Stations are in Stations.csv order
+	Stations []Station
+}
+
+// Routes is every daytime route serving the complex, sorted
+func (c Complex) Routes() []string {
+	seen := make(map[string]bool)
+	ret := make([]string, 0)
+	for _, st := range c.Stations {
+		for _, r := range st.DaytimeRoutes {
+			if !seen[r] {
+				seen[r] = true
+				ret = append(ret, r)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Name joins the distinct names of the complex's stations, eg Lexington Av/59 St / 59 St
+func (c Complex) Name() string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(c.Stations))
+	for _, st := range c.Stations {
+		if !seen[st.Name] {
+			seen[st.Name] = true
+			names = append(names, st.Name)
+		}
+	}
+	return strings.Join(names, " / ")
+}
+
+// LoadStations reads the MTA's Stations.csv (published alongside the GTFS bundle, see data/Stations.csv) from path
+func LoadStations(path string) ([]Station, error) {
+	return LoadCSV[Station](os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+// ParentStop returns the station the stop belongs to, so both F27N and F27S give F27. Stops without a parent station
+// are returned as is.
+func (s *Schedule) ParentStop(stopID string) (Stop, bool) {
+	stop, ok := s.stops[stopID]
+	if !ok {
+		return Stop{}, false
+	}
+	if stop.ParentStation == "" {
+		return stop, true
+	}
+	parent, ok := s.stops[stop.ParentStation]
+	if !ok {
+		// a dangling parent reference is a data problem, the stop itself is the best we can do
+		return stop, true
+	}
+	return parent, true
+}
+
+// StationIndex resolves the stop IDs used in realtime feeds, which are usually directional platforms, to their
+// Stations.csv records
+type StationIndex struct {
+	schedule  *Schedule
+	byGTFSID  map[string]Station
+	complexes map[int]*Complex
+}
+
+// NewStationIndex indexes the stations by GTFS stop ID and complex, resolving platforms through the schedule's stops
+func NewStationIndex(schedule *Schedule, stations []Station) *StationIndex {
+	ret := &StationIndex{
+		schedule:  schedule,
+		byGTFSID:  make(map[string]Station, len(stations)),
+		complexes: make(map[int]*Complex),
+	}
+	for _, st := range stations {
+		ret.byGTFSID[st.GTFSID] = st
+		c, ok := ret.complexes[st.ComplexID]
+		if !ok {
+			c = &Complex{ComplexID: st.ComplexID}
+			ret.complexes[st.ComplexID] = c
+		}
+		c.Stations = append(c.Stations, st)
+	}
+	return ret
+}
+
+// Station returns the Stations.csv record for the stop, which may be a platform (F27N) or the parent stop itself (F27)
+func (i *StationIndex) Station(stopID string) (Station, bool) {
+	if parent, ok := i.schedule.ParentStop(stopID); ok {
+		st, ok := i.byGTFSID[parent.StopID]
+		return st, ok
+	}
+	// not in stops.txt, which happens when the feed is ahead of (or behind) the static data. NYCT platform IDs are the
+	// parent ID plus a direction so fall back on that.
+	if st, ok := i.byGTFSID[stopID]; ok {
+		return st, true
+	}
+	if l := len(stopID); l > 1 && (stopID[l-1] == 'N' || stopID[l-1] == 'S') {
+		st, ok := i.byGTFSID[stopID[:l-1]]
+		return st, ok
+	}
+	return Station{}, false
+}
+
+// Complex returns the complex the stop (a platform, or parent stop) belongs to
+func (i *StationIndex) Complex(stopID string) (Complex, bool) {
+	st, ok := i.Station(stopID)
+	if !ok {
+		return Complex{}, false
+	}
+	return i.ComplexByID(st.ComplexID)
+}
+
+func (i *StationIndex) ComplexByID(complexID int) (Complex, bool) {
+	c, ok := i.complexes[complexID]
+	if !ok {
+		return Complex{}, false
+	}
+	return *c, true
+}
+
+// Complexes returns every complex, ordered by complex ID
+func (i *StationIndex) Complexes() []Complex {
+	ret := make([]Complex, 0, len(i.complexes))
+	for _, c := range i.complexes {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].ComplexID < ret[b].ComplexID
+	})
+	return ret
+}
+
+// SameComplex reports if the stops are in the same complex, and so connected by a free transfer
+func (i *StationIndex) SameComplex(stopA, stopB string) bool {
+	a, ok := i.Station(stopA)
+	if !ok {
+		return false
+	}
+	b, ok := i.Station(stopB)
+	return ok && a.ComplexID == b.ComplexID
+}
+
+// DirectionLabel describes where trains leaving from the directional platform (F27N, F27S) are headed, eg Manhattan.
+// False if the stop isn't a known directional platform or no label is published for it.
+func (i *StationIndex) DirectionLabel(stopID string) (string, bool) {
+	if len(stopID) < 2 {
+		return "", false
+	}
+	st, ok := i.Station(stopID)
+	if !ok {
+		return "", false
+	}
+	label := st.DirectionLabel(stopID[len(stopID)-1:])
+	return label, label != ""
+}
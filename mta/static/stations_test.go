@@ -0,0 +1,167 @@
+package static
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStationIndex indexes the fixture Stations.csv against the fixture schedule
+func testStationIndex(t *testing.T) *StationIndex {
+	schedule, err := Load(fixtures)
+	require.NoError(t, err)
+	stations, err := LoadStations(fixtures + "Stations.csv")
+	require.NoError(t, err)
+	return NewStationIndex(schedule, stations)
+}
+
+func TestLoad_Stops(t *testing.T) {
+	out := make([]Stop, 0)
+	MustLoad[Stop](fixtures, "stops.txt", &out)
+	require.Len(t, out, 9)
+	assert.Equal(t, Stop{
+		StopID:       "101",
+		Name:         "Van Cortlandt Park-242 St",
+		Latitude:     40.889248,
+		Longitude:    -73.898583,
+		LocationType: LocationStation,
+	}, out[0])
+	assert.Equal(t, Stop{
+		StopID:        "101N",
+		Name:          "Van Cortlandt Park-242 St",
+		Latitude:      40.889248,
+		Longitude:     -73.898583,
+		LocationType:  LocationPlatform,
+		ParentStation: "101",
+	}, out[1])
+
+	var lt LocationType
+	assert.Error(t, lt.UnmarshalText([]byte("7")))
+}
+
+func TestSchedule_ParentStop(t *testing.T) {
+	schedule, err := Load(fixtures)
+	require.NoError(t, err)
+
+	for _, id := range []string{"103N", "103S", "103"} {
+		parent, ok := schedule.ParentStop(id)
+		require.True(t, ok, id)
+		assert.Equal(t, "103", parent.StopID, id)
+		assert.Equal(t, LocationStation, parent.LocationType, id)
+	}
+
+	_, ok := schedule.ParentStop("F27N")
+	assert.False(t, ok)
+}
+
+func TestStationIndex_Station(t *testing.T) {
+	index := testStationIndex(t)
+
+	testCases := []struct {
+		stopID      string
+		expectedID  string
+		expectFound bool
+	}{
+		// platforms resolve through their parent
+		{stopID: "101N", expectedID: "101", expectFound: true},
+		{stopID: "101S", expectedID: "101", expectFound: true},
+		{stopID: "101", expectedID: "101", expectFound: true},
+		// in stops.txt but not Stations.csv
+		{stopID: "103N"},
+		// stops missing from stops.txt fall back on the NYCT naming convention
+		{stopID: "A02N", expectedID: "A02", expectFound: true},
+		{stopID: "L08", expectedID: "L08", expectFound: true},
+		{stopID: "F27N"},
+		{stopID: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.stopID, func(t *testing.T) {
+			st, ok := index.Station(tc.stopID)
+			assert.Equal(t, tc.expectFound, ok)
+			assert.Equal(t, tc.expectedID, st.GTFSID)
+		})
+	}
+}
+
+func TestStation_ADA(t *testing.T) {
+	stations, err := LoadStations(fixtures + "Stations.csv")
+	require.NoError(t, err)
+	byID := make(map[string]Station)
+	for _, st := range stations {
+		byID[st.GTFSID] = st
+	}
+
+	// fully accessible stations have no directional values, both directions follow the station
+	bedford := byID["L08"]
+	assert.Equal(t, ADAAccessible, bedford.NorthboundADA())
+	assert.Equal(t, ADAAccessible, bedford.SouthboundADA())
+
+	fortyNinth := byID["R15"]
+	assert.Equal(t, ADAPartiallyAccessible, fortyNinth.ADA)
+	assert.Equal(t, "Uptown & Queens", fortyNinth.ADANotes)
+	assert.Equal(t, ADAAccessible, fortyNinth.NorthboundADA())
+	assert.Equal(t, ADANotAccessible, fortyNinth.SouthboundADA())
+
+	b, err := json.Marshal(fortyNinth.ADA)
+	require.NoError(t, err)
+	assert.JSONEq(t, `"partial"`, string(b))
+
+	var ada ADA
+	assert.Error(t, ada.UnmarshalText([]byte("3")))
+}
+
+func TestStationIndex_Complexes(t *testing.T) {
+	index := testStationIndex(t)
+
+	lex, ok := index.Complex("R11N")
+	require.True(t, ok)
+	assert.Equal(t, 613, lex.ComplexID)
+	require.Len(t, lex.Stations, 2)
+	assert.Equal(t, "Lexington Av/59 St / 59 St", lex.Name())
+	assert.Equal(t, []string{"4", "5", "6", "N", "R", "W"}, lex.Routes())
+
+	fromIRT, ok := index.Complex("629S")
+	require.True(t, ok)
+	assert.Equal(t, lex, fromIRT)
+
+	assert.True(t, index.SameComplex("R11N", "629S"))
+	assert.False(t, index.SameComplex("R11N", "R15N"))
+	assert.False(t, index.SameComplex("R11N", "F27N"))
+
+	_, ok = index.Complex("F27N")
+	assert.False(t, ok)
+
+	complexes := index.Complexes()
+	assert.Len(t, complexes, 7)
+	for i := 1; i < len(complexes); i++ {
+		assert.Less(t, complexes[i-1].ComplexID, complexes[i].ComplexID)
+	}
+}
+
+func TestStationIndex_DirectionLabel(t *testing.T) {
+	index := testStationIndex(t)
+
+	testCases := []struct {
+		stopID        string
+		expectedLabel string
+		expectFound   bool
+	}{
+		{stopID: "101S", expectedLabel: "Manhattan", expectFound: true},
+		// Van Cortlandt Park is the northern terminal
+		{stopID: "101N"},
+		{stopID: "R11N", expectedLabel: "Queens", expectFound: true},
+		{stopID: "629N", expectedLabel: "Uptown & The Bronx", expectFound: true},
+		// parent stops have no direction
+		{stopID: "101"},
+		{stopID: "F27N"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.stopID, func(t *testing.T) {
+			label, ok := index.DirectionLabel(tc.stopID)
+			assert.Equal(t, tc.expectFound, ok)
+			assert.Equal(t, tc.expectedLabel, label)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMatchingTrip is returned when no scheduled trip running on the requested service day matches a trip ID
+var ErrNoMatchingTrip = errors.New("no matching scheduled trip")
+
+// TripID is the structure NYCT encodes in its trip IDs. Realtime IDs look like 084421_G..N, static IDs like
+// ASP23GEN-1037-Sunday-00_000600_1..S03R, the realtime ID being the static ID less the schedule and usually the path.
+type TripID struct {
+	// Schedule identifies the timetable the trip belongs to, only present in static IDs
+	Schedule string
+	// OriginTime is when the trip leaves its origin, relative to the start of the service day. It is encoded in
+	// hundredths of a minute (084421 is 844.21 minutes, 14:04:12.6) and may run past 24 hours.
+	OriginTime ServiceTime
+	RouteID    string
+	// Direction is N or S
+	Direction string
+	// Path identifies the stopping pattern (shape) the trip follows, usually only present in static IDs
+	Path string
+}
+
+// ParseTripID parses either a realtime or static NYCT trip ID
+func ParseTripID(raw string) (TripID, error) {
+	ret := TripID{}
+	// origin_route..direction[path] with an optional schedule_ in front for static IDs
+	parts := strings.Split(raw, "_")
+	switch len(parts) {
+	case 2:
+	case 3:
+		ret.Schedule = parts[0]
+		parts = parts[1:]
+	default:
+		return TripID{}, fmt.Errorf("invalid trip ID %q", raw)
+	}
+
+	hundredths, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts[0]) != 6 {
+		return TripID{}, fmt.Errorf("invalid origin time in trip ID %q", raw)
+	}
+	ret.OriginTime = ServiceTime(time.Duration(hundredths) * time.Minute / 100)
+
+	// the route is padded out with dots, the direction follows them
+	dots := strings.Index(parts[1], ".")
+	if dots < 1 {
+		return TripID{}, fmt.Errorf("invalid route in trip ID %q", raw)
+	}
+	ret.RouteID = parts[1][:dots]
+	rest := strings.TrimLeft(parts[1][dots:], ".")
+	if len(rest) == 0 || (rest[0] != 'N' && rest[0] != 'S') {
+		return TripID{}, fmt.Errorf("invalid direction in trip ID %q", raw)
+	}
+	ret.Direction = rest[:1]
+	ret.Path = rest[1:]
+	return ret, nil
+}
+
+// sameTrip reports if the IDs describe the same run, ignoring the schedule and treating a missing path as a wildcard
+func (t TripID) sameTrip(other TripID) bool {
+	if t.OriginTime != other.OriginTime || t.RouteID != other.RouteID || t.Direction != other.Direction {
+		return false
+	}
+	return t.Path == "" || other.Path == "" || t.Path == other.Path
+}
+
+// ServiceDay returns the service day a trip observed running at the given time most likely belongs to. Trips leaving
+// their origin late in the evening run into the next calendar day, so the candidate days are the one the observation
+// fell on and the day before, whichever puts the origin time closest to the observation.
+func (t TripID) ServiceDay(observedAt time.Time, loc *time.Location) Date {
+	observedDay := DateOf(observedAt.In(loc))
+	prior := observedDay.AddDays(-1)
+	if abs(observedAt.Sub(t.OriginTime.On(prior, loc))) < abs(observedAt.Sub(t.OriginTime.On(observedDay, loc))) {
+		return prior
+	}
+	return observedDay
+}
+
+type tripKey struct {
+	originTime ServiceTime
+	routeID    string
+	direction  string
+}
+
+// TripMatcher resolves realtime trip IDs to the static trips they run as
+type TripMatcher struct {
+	trips  map[tripKey][]matchableTrip
+	runsOn func(serviceID string, day Date) bool
+}
+
+type matchableTrip struct {
+	id   TripID
+	trip Trip
+}
+
+// NewTripMatcher indexes the given trips, any with IDs that can't be parsed are skipped. runsOn reports if a service
+// operates on a given day, see Schedule.ServiceRunsOn.
+func NewTripMatcher(trips []Trip, runsOn func(serviceID string, day Date) bool) *TripMatcher {
+	ret := &TripMatcher{
+		trips:  make(map[tripKey][]matchableTrip),
+		runsOn: runsOn,
+	}
+	for _, t := range trips {
+		id, err := ParseTripID(t.TripID)
+		if err != nil {
+			continue
+		}
+		key := tripKey{originTime: id.OriginTime, routeID: id.RouteID, direction: id.Direction}
+		ret.trips[key] = append(ret.trips[key], matchableTrip{id: id, trip: t})
+	}
+	return ret
+}
+
+// Match returns the static trip the realtime trip runs as on the given service day. When more than one trip matches,
+// one with the same path is preferred.
+func (m *TripMatcher) Match(realtime TripID, day Date) (Trip, error) {
+	var found *Trip
+	for _, candidate := range m.trips[tripKey{originTime: realtime.OriginTime, routeID: realtime.RouteID, direction: realtime.Direction}] {
+		if !candidate.id.sameTrip(realtime) {
+			continue
+		}
+		if !m.runsOn(candidate.trip.ServiceID, day) {
+			continue
+		}
+		if found == nil || (realtime.Path != "" && candidate.id.Path == realtime.Path) {
+			trip := candidate.trip
+			found = &trip
+		}
+	}
+	if found == nil {
+		return Trip{}, ErrNoMatchingTrip
+	}
+	return *found, nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
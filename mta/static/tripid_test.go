@@ -0,0 +1,84 @@
+package static
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTripID(t *testing.T) {
+	testCases := []struct {
+		raw           string
+		expected      TripID
+		expectedError string
+	}{
+		{
+			raw: "084421_G..N",
+			expected: TripID{
+				OriginTime: ServiceTime(time.Hour*14 + time.Minute*4 + time.Millisecond*12600),
+				RouteID:    "G",
+				Direction:  "N",
+			},
+		},
+		{
+			raw: "ASP23GEN-1037-Sunday-00_000600_1..S03R",
+			expected: TripID{
+				Schedule:   "ASP23GEN-1037-Sunday-00",
+				OriginTime: ServiceTime(time.Minute * 6),
+				RouteID:    "1",
+				Direction:  "S",
+				Path:       "03R",
+			},
+		},
+		{
+			raw: "123450_GS.N",
+			expected: TripID{
+				OriginTime: ServiceTime(time.Hour*20 + time.Minute*34 + time.Second*30),
+				RouteID:    "GS",
+				Direction:  "N",
+			},
+		},
+		{raw: "G..N", expectedError: `invalid trip ID "G..N"`},
+		{raw: "08442_G..N", expectedError: `invalid origin time in trip ID "08442_G..N"`},
+		{raw: "084421_G..X", expectedError: `invalid direction in trip ID "084421_G..X"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.raw, func(t *testing.T) {
+			got, err := ParseTripID(tc.raw)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestTripMatcher_Match(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	schedule, err := Load(fixtures)
+	require.NoError(t, err)
+	matcher := schedule.TripMatcher()
+
+	id, err := ParseTripID("143850_1..N")
+	require.NoError(t, err)
+	// observed just after midnight monday, but it's sundays trip
+	day := id.ServiceDay(time.Date(2023, 7, 24, 0, 1, 0, 0, loc), loc)
+	assert.Equal(t, Date{Year: 2023, Month: time.July, Day: 23}, day)
+	got, err := matcher.Match(id, day)
+	require.NoError(t, err)
+	assert.Equal(t, "ASP23GEN-1037-Sunday-00_143850_1..N03R", got.TripID)
+
+	// there is no weekday version of the trip
+	_, err = matcher.Match(id, Date{Year: 2023, Month: time.July, Day: 24})
+	assert.ErrorIs(t, err, ErrNoMatchingTrip)
+
+	// a differing path rules a trip out
+	id.Path = "01R"
+	_, err = matcher.Match(id, day)
+	assert.ErrorIs(t, err, ErrNoMatchingTrip)
+}
@@ -2,36 +2,135 @@ package mta
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
 type Feed interface {
+	Name() string
 	Feed(ctx context.Context) (TripStatus, error)
 }
 
+// FeedReport describes the outcome of pulling a single feed
+type FeedReport struct {
+	Name string `json:"name"`
+	// Stale is true when the feed had not advanced since the prior pull
+	Stale bool `json:"stale"`
+	// Latency is how long pulling the feed took
+	Latency time.Duration `json:"latency"`
+	// Err is set if the feed could not be pulled, in which case none of its trips are present in the SystemState
+	Err error `json:"-"`
+}
+
+// Healthy reports if the feed was pulled and has current data
+func (f FeedReport) Healthy() bool {
+	return f.Err == nil && !f.Stale
+}
+
+// SystemState is the state of the entire transit system, assembled from all of its feeds
+type SystemState struct {
+	TripUpdates []TripUpdate
+	Feeds       []FeedReport
+}
+
+// unreliableFeeds returns the names of feeds whose trips should be left as they were, rather than having their absence
+// (or presence) acted upon
+func (s SystemState) unreliableFeeds() map[string]bool {
+	ret := make(map[string]bool)
+	for _, f := range s.Feeds {
+		if !f.Healthy() {
+			ret[f.Name] = true
+		}
+	}
+	return ret
+}
+
 type TransitSystem struct {
-	feeds []Feed
+	feeds       []Feed
+	concurrency int
+	feedTimeout time.Duration
+}
+
+type TransitSystemOption func(t *TransitSystem)
+
+// WithConcurrency bounds how many feeds are pulled at once, defaults to 4
+func WithConcurrency(concurrency int) TransitSystemOption {
+	return func(t *TransitSystem) {
+		t.concurrency = concurrency
+	}
 }
 
-func NewTransitSystem(feeds ...Feed) *TransitSystem {
-	return &TransitSystem{
-		feeds: feeds,
+// WithFeedTimeout bounds how long pulling any single feed may take, retries included. Defaults to 30 seconds.
+func WithFeedTimeout(timeout time.Duration) TransitSystemOption {
+	return func(t *TransitSystem) {
+		t.feedTimeout = timeout
+	}
+}
+
+func NewTransitSystem(feeds []Feed, opts ...TransitSystemOption) *TransitSystem {
+	ret := &TransitSystem{
+		feeds:       feeds,
+		concurrency: 4,
+		feedTimeout: time.Second * 30,
+	}
+	for _, o := range opts {
+		o(ret)
 	}
+	return ret
 }
 
-func (t *TransitSystem) CurrentState(ctx context.Context) ([]TripUpdate, error) {
-	ret := make([]TripUpdate, 0)
+type feedResult struct {
+	status  TripStatus
+	err     error
+	latency time.Duration
+}
+
+// CurrentState pulls all feeds and merges their trips. A feed failing does not fail the whole pull, the failure is
+// reported in the feed's FeedReport and its trips are left out. An error is only returned if every feed failed.
+func (t *TransitSystem) CurrentState(ctx context.Context) (SystemState, error) {
+	results := t.pullAll(ctx)
+
+	// merging happens in feed order so the result is deterministic regardless of which pull finished first
+	ret := SystemState{
+		TripUpdates: make([]TripUpdate, 0),
+		Feeds:       make([]FeedReport, 0, len(t.feeds)),
+	}
 	dropCount := 0
-	for _, f := range t.feeds {
-		status, err := f.Feed(ctx)
-		if err != nil {
-			return nil, err
+	errs := make([]error, 0)
+	for i, f := range t.feeds {
+		res := results[i]
+		zerolog.Ctx(ctx).Debug().Str("feed", f.Name()).Dur("latency", res.latency).Msg("feed pulled")
+		if res.err != nil {
+			zerolog.Ctx(ctx).Warn().Err(res.err).Str("feed", f.Name()).Msg("unable to pull feed, its trips will be left as they were")
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name(), res.err))
+			ret.Feeds = append(ret.Feeds, FeedReport{
+				Name:    f.Name(),
+				Latency: res.latency,
+				Err:     res.err,
+			})
+			continue
+		}
+		status := res.status
+		for _, decodeErr := range status.DecodeErrors {
+			zerolog.Ctx(ctx).Warn().Err(decodeErr).Str("feed", f.Name()).Msg("problem decoding feed")
 		}
+		if status.Stale {
+			zerolog.Ctx(ctx).Info().Str("feed", f.Name()).Msg("feed is stale, its trips will be left as they were")
+		}
+		ret.Feeds = append(ret.Feeds, FeedReport{
+			Name:    f.Name(),
+			Stale:   status.Stale,
+			Latency: res.latency,
+		})
 		for _, tu := range status.TripUpdates {
 			zerolog.Ctx(ctx).Trace().Interface("trip", tu).Msg("trip observed")
 			if tu.IsAssigned {
-				ret = append(ret, tu)
+				tu.Feed = f.Name()
+				ret.TripUpdates = append(ret.TripUpdates, tu)
 			} else {
 				dropCount++
 			}
@@ -40,5 +139,49 @@ func (t *TransitSystem) CurrentState(ctx context.Context) ([]TripUpdate, error)
 	if dropCount > 0 {
 		zerolog.Ctx(ctx).Info().Int("dropCount", dropCount).Msg("filtered out unassigned trips")
 	}
+	if len(t.feeds) > 0 && len(errs) == len(t.feeds) {
+		return SystemState{}, errors.Join(errs...)
+	}
 	return ret, nil
 }
+
+// pullAll pulls every feed using a bounded pool of workers, results are indexed the same as t.feeds
+func (t *TransitSystem) pullAll(ctx context.Context) []feedResult {
+	results := make([]feedResult, len(t.feeds))
+	workers := t.concurrency
+	if workers < 1 || workers > len(t.feeds) {
+		workers = len(t.feeds)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = t.pull(ctx, t.feeds[i])
+			}
+		}()
+	}
+	for i := range t.feeds {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (t *TransitSystem) pull(ctx context.Context, f Feed) feedResult {
+	if t.feedTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.feedTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	status, err := f.Feed(ctx)
+	return feedResult{
+		status:  status,
+		err:     err,
+		latency: time.Since(start),
+	}
+}
@@ -0,0 +1,132 @@
+package mta
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubFeed struct {
+	name   string
+	delay  time.Duration
+	status TripStatus
+	err    error
+
+	mutex    *sync.Mutex
+	inFlight *int
+	maxSeen  *int
+}
+
+func (s stubFeed) Name() string {
+	return s.name
+}
+
+func (s stubFeed) Feed(ctx context.Context) (TripStatus, error) {
+	if s.mutex != nil {
+		s.mutex.Lock()
+		*s.inFlight++
+		if *s.inFlight > *s.maxSeen {
+			*s.maxSeen = *s.inFlight
+		}
+		s.mutex.Unlock()
+		defer func() {
+			s.mutex.Lock()
+			*s.inFlight--
+			s.mutex.Unlock()
+		}()
+	}
+	select {
+	case <-time.After(s.delay):
+		return s.status, s.err
+	case <-ctx.Done():
+		return TripStatus{}, ctx.Err()
+	}
+}
+
+func assignedTrip(tripID string) TripUpdate {
+	return TripUpdate{TripId: tripID, IsAssigned: true}
+}
+
+func TestTransitSystem_CurrentState_Concurrent(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxSeen := 0, 0
+	feed := func(name string, delay time.Duration, trips ...TripUpdate) Feed {
+		return stubFeed{
+			name:     name,
+			delay:    delay,
+			status:   TripStatus{TripUpdates: trips},
+			mutex:    &mutex,
+			inFlight: &inFlight,
+			maxSeen:  &maxSeen,
+		}
+	}
+
+	// the slowest feeds come first so completion order differs from feed order
+	feeds := []Feed{
+		feed("ace", time.Millisecond*60, assignedTrip("A1"), assignedTrip("A2")),
+		feed("g", time.Millisecond*40, assignedTrip("G1"), TripUpdate{TripId: "G2"}),
+		feed("l", time.Millisecond*20, assignedTrip("L1")),
+		feed("si", 0, assignedTrip("S1")),
+	}
+
+	state, err := NewTransitSystem(feeds, WithConcurrency(2)).CurrentState(context.Background())
+	require.NoError(t, err)
+
+	tripIDs := make([]string, len(state.TripUpdates))
+	for i, tu := range state.TripUpdates {
+		tripIDs[i] = tu.TripId
+	}
+	assert.Equal(t, []string{"A1", "A2", "G1", "L1", "S1"}, tripIDs)
+	assert.Equal(t, "g", state.TripUpdates[2].Feed)
+	assert.Equal(t, 2, maxSeen)
+
+	require.Len(t, state.Feeds, 4)
+	for i, name := range []string{"ace", "g", "l", "si"} {
+		assert.Equal(t, name, state.Feeds[i].Name)
+	}
+	assert.GreaterOrEqual(t, state.Feeds[0].Latency, time.Millisecond*60)
+	assert.Less(t, state.Feeds[3].Latency, time.Millisecond*20)
+}
+
+func TestTransitSystem_CurrentState_FeedTimeout(t *testing.T) {
+	feeds := []Feed{
+		stubFeed{name: "slow", delay: time.Second},
+	}
+	_, err := NewTransitSystem(feeds, WithFeedTimeout(time.Millisecond*10)).CurrentState(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTransitSystem_CurrentState_PartialFailure(t *testing.T) {
+	feeds := []Feed{
+		stubFeed{name: "ace", status: TripStatus{TripUpdates: []TripUpdate{assignedTrip("A1")}}},
+		stubFeed{name: "si", err: errors.New("kaboom")},
+		stubFeed{name: "l", status: TripStatus{TripUpdates: []TripUpdate{assignedTrip("L1")}}},
+	}
+	state, err := NewTransitSystem(feeds).CurrentState(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, state.TripUpdates, 2)
+	assert.Equal(t, "A1", state.TripUpdates[0].TripId)
+	assert.Equal(t, "L1", state.TripUpdates[1].TripId)
+
+	require.Len(t, state.Feeds, 3)
+	assert.True(t, state.Feeds[0].Healthy())
+	assert.Equal(t, "si", state.Feeds[1].Name)
+	assert.EqualError(t, state.Feeds[1].Err, "kaboom")
+	assert.False(t, state.Feeds[1].Healthy())
+	assert.True(t, state.Feeds[2].Healthy())
+}
+
+func TestTransitSystem_CurrentState_TotalFailure(t *testing.T) {
+	feeds := []Feed{
+		stubFeed{name: "ace", err: errors.New("kaboom")},
+		stubFeed{name: "si", err: errors.New("splat")},
+	}
+	_, err := NewTransitSystem(feeds).CurrentState(context.Background())
+	assert.EqualError(t, err, "ace: kaboom\nsi: splat")
+}
@@ -0,0 +1,113 @@
+package mta
+
+import "time"
+
+// CompletedTrip summarizes a trip from end to end once it has run its last segment. Trips are only tracked from when
+// they first appear in the feed, so for trips already underway when tracking started the origin is the first stop
+// observed rather than where the train actually set out from.
+type CompletedTrip struct {
+	TripID     string     `json:"tripId"`
+	RouteID    string     `json:"routeId"`
+	TrainID    string     `json:"trainId"`
+	IsAssigned bool       `json:"isAssigned"`
+	Direction  *Direction `json:"direction,omitempty"`
+	Origin     string     `json:"origin"`
+	Terminus   string     `json:"terminus"`
+	// DepartAt is the departure from the origin
+	DepartAt time.Time `json:"departAt"`
+	// ArriveAt is the arrival at the terminus
+	ArriveAt time.Time `json:"arriveAt"`
+	// Stops are every stop made, in order
+	Stops []StopTimeUpdate `json:"stops"`
+	// TrackChanges counts the stops where the train ran on a different track than it was scheduled to
+	TrackChanges int `json:"trackChanges"`
+	// HasInferredStops is true if any stop was assumed complete rather than seen to drop off the feed
+	HasInferredStops bool `json:"hasInferredStops"`
+}
+
+// Duration is the end to end run time of the trip
+func (c CompletedTrip) Duration() time.Duration {
+	return c.ArriveAt.Sub(c.DepartAt)
+}
+
+func newCompletedTrip(trip TripUpdate, stops []StopTimeUpdate) *CompletedTrip {
+	if len(stops) == 0 {
+		return nil
+	}
+	first := stops[0]
+	last := stops[len(stops)-1]
+	ret := &CompletedTrip{
+		TripID:     trip.TripId,
+		RouteID:    trip.RouteId,
+		TrainID:    trip.TrainId,
+		IsAssigned: trip.IsAssigned,
+		Direction:  trip.Direction,
+		Origin:     first.StopID,
+		Terminus:   last.StopID,
+		Stops:      stops,
+	}
+	// the origin may only carry a departure and the terminus only an arrival, fall back on the other if not
+	if t := firstTime(first.Departure, first.Arrival); t != nil {
+		ret.DepartAt = *t
+	}
+	if t := firstTime(last.Arrival, last.Departure); t != nil {
+		ret.ArriveAt = *t
+	}
+	for _, s := range stops {
+		if s.ScheduledTrack != nil && s.ActualTrack != nil && *s.ScheduledTrack != *s.ActualTrack {
+			ret.TrackChanges++
+		}
+		if s.Inferred {
+			ret.HasInferredStops = true
+		}
+	}
+	return ret
+}
+
+// StopVisit is a train's stay at a single stop. Dwell is only as good as the times the feed provided, predicted arrival
+// and departure times are usually identical so a meaningful dwell generally requires the arrival to have been observed
+// (see WithStoppedAtArrivals).
+type StopVisit struct {
+	StopID    string     `json:"stopId"`
+	TripID    string     `json:"tripId"`
+	RouteID   string     `json:"routeId"`
+	TrainID   string     `json:"trainId"`
+	Arrival   *time.Time `json:"arrival,omitempty"`
+	Departure *time.Time `json:"departure,omitempty"`
+	// Dwell is the time between arrival and departure, zero if either is unknown
+	Dwell          time.Duration `json:"dwell"`
+	ScheduledTrack *string       `json:"scheduledTrack,omitempty"`
+	ActualTrack    *string       `json:"actualTrack,omitempty"`
+	// ArrivalObserved is true when the arrival was observed rather than predicted
+	ArrivalObserved bool `json:"arrivalObserved,omitempty"`
+	// Inferred is true when the visit was assumed rather than seen to drop off the feed
+	Inferred bool `json:"inferred,omitempty"`
+}
+
+func newStopVisit(trip TripUpdate, stop StopTimeUpdate) StopVisit {
+	ret := StopVisit{
+		StopID:          stop.StopID,
+		TripID:          trip.TripId,
+		RouteID:         trip.RouteId,
+		TrainID:         trip.TrainId,
+		Arrival:         stop.Arrival,
+		Departure:       stop.Departure,
+		ScheduledTrack:  stop.ScheduledTrack,
+		ActualTrack:     stop.ActualTrack,
+		ArrivalObserved: stop.ArrivalObserved,
+		Inferred:        stop.Inferred,
+	}
+	if stop.Arrival != nil && stop.Departure != nil && stop.Departure.After(*stop.Arrival) {
+		ret.Dwell = stop.Departure.Sub(*stop.Arrival)
+	}
+	return ret
+}
+
+func firstTime(times ...*time.Time) *time.Time {
+	for _, t := range times {
+		if t != nil {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package mta
+
+import "time"
+
+type TripEventType string
+
+const (
+	// TripStarted is emitted when a trip is seen for the first time
+	TripStarted TripEventType = "started"
+	// TripCompleted is emitted when a trip has run its last segment
+	TripCompleted TripEventType = "completed"
+	// TripVanished is emitted when a trip with completed stops drops off the feed and is held on to in case it comes back
+	TripVanished TripEventType = "vanished"
+	// TripReappeared is emitted when a trip that vanished shows up in the feed again
+	TripReappeared TripEventType = "reappeared"
+	// TripDiscarded is emitted when a trip drops off the feed and is given up on without being completed, either
+	// straight away or after having vanished for too long
+	TripDiscarded TripEventType = "discarded"
+)
+
+// TripEvent marks a change in a trips lifecycle, as observed by StateProcessor
+type TripEvent struct {
+	Type    TripEventType `json:"type"`
+	TripID  string        `json:"tripId"`
+	RouteID string        `json:"routeId"`
+	// Feed is the name of the feed the trip was observed in
+	Feed string `json:"feed,omitempty"`
+	// At is when the processing run that observed the change happened
+	At time.Time `json:"at"`
+}